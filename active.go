@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// runActive reports the bank of an A/B image the device boots from.
+func runActive(args []string) error {
+	fs := newFlagSet("active")
+	f := addImageFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if isFlagPassed(fs, "bank") {
+		return argErrorf("-bank cannot be used with active")
+	}
+	*f.bank = "all"
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, banks, err := f.openBanks(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for i, b := range banks {
+		if err := b.Verify(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: bank %s: %v, ignored\n", bankName(i), err)
+		}
+	}
+	i, err := sbfs.ActiveBank(banks)
+	if err != nil {
+		return fmt.Errorf("no valid bank: %w", err)
+	}
+	img := banks[i]
+	fmt.Printf("\n=== SBFS Active Bank ===\n")
+	fmt.Printf("%16s: %s (of %d)\n", "Bank", bankName(i), len(banks))
+	fmt.Printf("%16s: 0x%06X\n", "Header Offset", img.HeaderOffset)
+	fmt.Printf("%16s: 0x%02X\n", "Sequence Number", img.Header.Header.SequenceNumber)
+	fmt.Printf("\n")
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// subtractRanges returns the parts of ranges that lie outside all of used.
+func subtractRanges(ranges, used []sbfs.Range) []sbfs.Range {
+	for _, u := range used {
+		var rest []sbfs.Range
+		for _, r := range ranges {
+			if u.End <= r.Start || u.Start >= r.End {
+				rest = append(rest, r)
+				continue
+			}
+			if r.Start < u.Start {
+				rest = append(rest, sbfs.Range{Start: r.Start, End: u.Start})
+			}
+			if u.End < r.End {
+				rest = append(rest, sbfs.Range{Start: u.End, End: r.End})
+			}
+		}
+		ranges = rest
+	}
+	return ranges
+}
+
+// runCanonicalize rewrites the bytes of an image that carry no content, so that
+// dumps with the same header and files compare equal byte for byte: the ranges
+// outside the header and every file are zeroed, the 0xFF block padding at the end of
+// files becomes 0x00 and the checksum is recomputed. The region before the header is
+// kept, as are the headers and files of the other banks of an A/B image.
+func runCanonicalize(args []string) error {
+	fs := newFlagSet("canonicalize")
+	f := addImageFlags(fs)
+	outputFile := fs.String("o", "", "output file (default: input file + .out). Using the input file keeps a .bak copy")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its header and checksum")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if isFlagPassed(fs, "base") {
+		return argErrorf("-base cannot be used with canonicalize, the end of the SBFS region is not known")
+	}
+	if *f.input == "-" && !isFlagPassed(fs, "o") {
+		return argErrorf("-o is required when reading the image from stdin")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// the padding of one file could be the contents of another
+	if overlaps, _ := img.CheckLayout(); len(overlaps) > 0 {
+		o := overlaps[0]
+		return fmt.Errorf("%s and %s overlap at 0x%06X-0x%06X, cannot tell padding from contents",
+			sbfs.FileName(o.A), sbfs.FileName(o.B), o.Start, o.End)
+	}
+
+	fmt.Printf("\n=== Canonicalizing SBFS ===\n")
+	bs := int(img.Options().BlockSize)
+	for _, fi := range img.Files {
+		if fi.Length == 0x00 || img.CheckBounds(fi) != nil {
+			continue
+		}
+		data, err := io.ReadAll(img.Section(fi))
+		if err != nil {
+			return fmt.Errorf("%s: %w", sbfs.FileName(fi.Index), err)
+		}
+		pad := paddingLen(data, bs-1)
+		if pad == 0 || data[len(data)-1] != 0xFF {
+			continue
+		}
+		clear(data[len(data)-pad:])
+		if err = img.Replace(fi.Index, data); err != nil {
+			return fmt.Errorf("%s: %w", sbfs.FileName(fi.Index), err)
+		}
+		fmt.Printf("%20s: 0x%04X bytes of 0xFF padding zeroed\n", sbfs.FileName(fi.Index), pad)
+	}
+	// the other banks of an A/B image are not unused space
+	banks, err := sbfs.ParseBanks(file, opts)
+	if err != nil {
+		return err
+	}
+	var used []sbfs.Range
+	for _, b := range banks {
+		if b.HeaderOffset == img.HeaderOffset {
+			continue
+		}
+		used = append(used, sbfs.Range{Start: b.HeaderOffset, End: b.HeaderOffset + int64(b.Header.Size())})
+		for _, fi := range b.Files {
+			if fi.Length != 0x00 {
+				used = append(used, sbfs.Range{Start: fi.Offset, End: fi.Offset + fi.Length})
+			}
+		}
+	}
+	for _, g := range subtractRanges(img.Unclaimed(), used) {
+		img.Fill(g, 0x00)
+		fmt.Printf("%20s: 0x%06X-0x%06X zeroed\n", "Unused", g.Start, g.End)
+	}
+	if err = img.UpdateChecksum(); err != nil {
+		return fmt.Errorf("Cannot compute checksum: %w", err)
+	}
+	fmt.Printf("%20s: 0x%02X\n", "New checksum", img.Header.Checksum)
+
+	outFileName := *f.input + ".out"
+	if isFlagPassed(fs, "o") {
+		outFileName = *outputFile
+	}
+	return writeImage(file, img, *f.input, outFileName, false, true, !*noVerify)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+func TestSubtractRanges(t *testing.T) {
+	ranges := []sbfs.Range{{Start: 0x100, End: 0x1000}, {Start: 0x2000, End: 0x3000}}
+	// the header of a second bank and one of its files, which ends past the range
+	used := []sbfs.Range{{Start: 0x800, End: 0x900}, {Start: 0x2800, End: 0x4000}}
+	got := subtractRanges(ranges, used)
+	want := []sbfs.Range{{Start: 0x100, End: 0x800}, {Start: 0x900, End: 0x1000}, {Start: 0x2000, End: 0x2800}}
+	if !slices.Equal(got, want) {
+		t.Errorf("subtractRanges = %#x, want %#x", got, want)
+	}
+}
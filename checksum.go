@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// runChecksum prints the stored header checksum, and with -compute the recomputed
+// one, as bare hex lines for scripts.
+func runChecksum(args []string) error {
+	fs := newFlagSet("checksum")
+	f := addImageFlags(fs)
+	compute := fs.Bool("compute", false, "Also print the recomputed checksum on a second line, exit 3 if it differs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Println(hex.EncodeToString(img.Header.Checksum[:]))
+	if !*compute {
+		return nil
+	}
+	sum, err := img.ComputeChecksum(img.Options().ChecksumScope)
+	if err != nil {
+		return fmt.Errorf("Cannot compute checksum: %w", err)
+	}
+	fmt.Println(hex.EncodeToString(sum[:]))
+	if sum != img.Header.Checksum {
+		return exitStatus(exitBadChecksum)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "os"
+
+// ANSI escape sequences used to highlight the info output
+const (
+	ansiGreen = "\x1b[32m"
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+)
+
+// colorOutput enables highlighting, set from -color by setColor.
+var colorOutput bool
+
+// setColor interprets a -color mode. auto colors only when stdout is a terminal
+// and NO_COLOR is not set.
+func setColor(mode string) error {
+	switch mode {
+	case "always":
+		colorOutput = true
+	case "never":
+		colorOutput = false
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		colorOutput = err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	default:
+		return argErrorf("Invalid color mode: %s", mode)
+	}
+	return nil
+}
+
+func paint(code, s string) string {
+	if !colorOutput {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func green(s string) string { return paint(ansiGreen, s) }
+
+func red(s string) string { return paint(ansiRed, s) }
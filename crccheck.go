@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// fileCRCs are the checksums -check-file-crc looks for in the unknown bytes of the
+// file table entries.
+var fileCRCs = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"crc32-ieee", func() hash.Hash { return crc32.NewIEEE() }},
+	{"crc32-castagnoli", func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+	{"crc32-koopman", func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Koopman)) }},
+	{"crc64-iso", func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ISO)) }},
+	{"crc64-ecma", func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) }},
+}
+
+// printFileCRCs is an experiment testing whether the unknown bytes of a file table
+// entry hold a CRC of the file's contents. Every CRC of fileCRCs is computed over
+// each file and searched for in either byte order.
+func printFileCRCs(w io.Writer, img *sbfs.Image) error {
+	fmt.Fprintf(w, "\n=== SBFS File CRCs (experimental) ===\n")
+	matches := 0
+	for _, f := range img.Files {
+		if f.Length == 0x00 || img.CheckBounds(f) != nil {
+			continue
+		}
+		unknown := img.Header.Header.Files[f.Index].Unknown[:]
+		found := false
+		for _, c := range fileCRCs {
+			h := c.new()
+			if _, err := io.Copy(h, img.Section(f)); err != nil {
+				return fmt.Errorf("%s: %w", sbfs.FileName(f.Index), err)
+			}
+			// hash.Hash32/64 Sum returns the value big-endian
+			be := h.Sum(nil)
+			le := make([]byte, len(be))
+			if len(be) == 4 {
+				binary.LittleEndian.PutUint32(le, binary.BigEndian.Uint32(be))
+			} else {
+				binary.LittleEndian.PutUint64(le, binary.BigEndian.Uint64(be))
+			}
+			for _, v := range []struct {
+				order string
+				sum   []byte
+			}{{"little-endian", le}, {"big-endian", be}} {
+				if i := bytes.Index(unknown, v.sum); i >= 0 {
+					fmt.Fprintf(w, "%16s: %s %s at Unknown[%d:%d]\n", sbfs.FileName(f.Index), c.name, v.order, i, i+len(v.sum))
+					found = true
+					matches++
+				}
+			}
+		}
+		if !found {
+			fmt.Fprintf(w, "%16s: no match in % X\n", sbfs.FileName(f.Index), unknown)
+		}
+	}
+	if matches == 0 {
+		fmt.Fprintf(w, "%16s: none of %d CRCs found in the unknown bytes\n", "Result", len(fileCRCs))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"strconv"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// csvColumns is the header row written by writeCSV.
+var csvColumns = []string{"name", "index", "offset", "length", "sha256", "empty"}
+
+// writeCSV prints every entry of the file table as a CSV row, empty slots included.
+// The SHA256 is left blank for empty slots and files past the end of the image.
+func writeCSV(w io.Writer, img *sbfs.Image) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+	for _, f := range img.Files {
+		empty := f.Length == 0x00
+		var sum string
+		if !empty && img.CheckBounds(f) == nil {
+			h := sha256.New()
+			if _, err := io.Copy(h, img.Section(f)); err != nil {
+				return err
+			}
+			sum = hex.EncodeToString(h.Sum(nil))
+		}
+		cw.Write([]string{
+			sbfs.FileName(f.Index),
+			strconv.Itoa(f.Index),
+			strconv.FormatInt(f.Offset, 10),
+			strconv.FormatInt(f.Length, 10),
+			sum,
+			strconv.FormatBool(empty),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// deltaMagic starts the patch files written by inject -patch.
+const deltaMagic = "SBFSDLT1"
+
+// deltaRun is a range of bytes that differs from the original image.
+type deltaRun struct {
+	offset int64
+	data   []byte
+}
+
+// diffWriter compares the bytes written to it with orig at the same offsets and
+// collects the runs that differ.
+type diffWriter struct {
+	orig io.ReaderAt
+	pos  int64
+	buf  []byte
+	runs []deltaRun
+}
+
+func (d *diffWriter) Write(p []byte) (int, error) {
+	if len(d.buf) < len(p) {
+		d.buf = make([]byte, len(p))
+	}
+	old := d.buf[:len(p)]
+	n, err := d.orig.ReadAt(old, d.pos)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i, b := range p {
+		if i < n && old[i] == b {
+			continue
+		}
+		off := d.pos + int64(i)
+		if last := len(d.runs) - 1; last >= 0 && d.runs[last].offset+int64(len(d.runs[last].data)) == off {
+			d.runs[last].data = append(d.runs[last].data, b)
+		} else {
+			d.runs = append(d.runs, deltaRun{offset: off, data: []byte{b}})
+		}
+	}
+	d.pos += int64(len(p))
+	return len(p), nil
+}
+
+// writeDelta writes runs to the file name: deltaMagic, the header offset as an
+// int64, the number of runs as a uint32, then each run as its offset relative to
+// the header (int64), its length (uint32) and its bytes. All numbers are
+// little-endian. Storing the header offset makes the patch self-locating: it can
+// be checked against, or rebased onto, the header of the image it is applied to.
+func writeDelta(name string, headerOffset int64, runs []deltaRun) error {
+	out := []byte(deltaMagic)
+	out = binary.LittleEndian.AppendUint64(out, uint64(headerOffset))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(runs)))
+	for _, r := range runs {
+		out = binary.LittleEndian.AppendUint64(out, uint64(r.offset-headerOffset))
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(r.data)))
+		out = append(out, r.data...)
+	}
+	return os.WriteFile(name, out, 0644)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+func runDiff(args []string) error {
+	fs := newFlagSet("diff")
+	f := addImageFlags(fs)
+	diffFile := fs.String("diff", "", "image to compare with, may also be given as argument")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *diffFile == "" && fs.NArg() == 1 {
+		*diffFile = fs.Arg(0)
+	} else if *diffFile == "" || fs.NArg() > 0 {
+		return argErrorf("diff requires exactly one image to compare with")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	other, otherImg, err := openImage(*diffFile, opts)
+	if err != nil {
+		return err
+	}
+	defer other.Close()
+
+	diffs, err := diffImages(os.Stdout, filepath.Base(*f.input), img, filepath.Base(*diffFile), otherImg)
+	if err != nil {
+		return err
+	}
+	if diffs > 0 {
+		return errDiffers
+	}
+	return nil
+}
+
+// hashFile returns the SHA256 of the contents of f.
+func hashFile(img *sbfs.Image, f sbfs.FileInfo) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, img.Section(f)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// diffImages prints the fields that differ between a and b and returns how many did.
+func diffImages(w io.Writer, nameA string, a *sbfs.Image, nameB string, b *sbfs.Image) (int, error) {
+	var diffs int
+	row := func(field string, va, vb string) {
+		if diffs == 0 {
+			fmt.Fprintf(w, "\n=== SBFS Diff ===\n")
+			fmt.Fprintf(w, "%28s %20s %20s\n", "Field", nameA, nameB)
+		}
+		fmt.Fprintf(w, "%28s %20s %20s\n", field, va, vb)
+		diffs++
+	}
+	hex8 := func(v byte) string { return fmt.Sprintf("0x%02X", v) }
+	hex24 := func(v int64) string { return fmt.Sprintf("0x%06X", v) }
+
+	ha, hb := &a.Header.Header, &b.Header.Header
+	if ha.FormatVersion != hb.FormatVersion {
+		row("Format Version", hex8(ha.FormatVersion), hex8(hb.FormatVersion))
+	}
+	if ha.SequenceNumber != hb.SequenceNumber {
+		row("Sequence Number", hex8(ha.SequenceNumber), hex8(hb.SequenceNumber))
+	}
+	if ha.LayoutVersion != hb.LayoutVersion {
+		row("Layout Version", hex8(ha.LayoutVersion), hex8(hb.LayoutVersion))
+	}
+
+	// layouts may differ in their number of slots, missing ones count as empty
+	for i := 0; i < max(len(a.Files), len(b.Files)); i++ {
+		var fa, fb sbfs.FileInfo
+		if i < len(a.Files) {
+			fa = a.Files[i]
+		}
+		if i < len(b.Files) {
+			fb = b.Files[i]
+		}
+		if fa.Length == 0x00 && fb.Length == 0x00 {
+			continue
+		}
+		name := sbfs.FileName(i)
+		if fa.Offset != fb.Offset {
+			row(name+" Offset", hex24(fa.Offset), hex24(fb.Offset))
+		}
+		if fa.Length != fb.Length {
+			row(name+" Length", hex24(fa.Length), hex24(fb.Length))
+		}
+		if fa.Length == 0x00 || fb.Length == 0x00 {
+			continue
+		}
+		suma, err := hashFile(a, fa)
+		if err != nil {
+			return diffs, fmt.Errorf("%s: %s: %w", nameA, name, err)
+		}
+		sumb, err := hashFile(b, fb)
+		if err != nil {
+			return diffs, fmt.Errorf("%s: %s: %w", nameB, name, err)
+		}
+		if string(suma) != string(sumb) {
+			// abbreviated, enough to tell contents apart
+			row(name+" SHA256", fmt.Sprintf("%x", suma[:8]), fmt.Sprintf("%x", sumb[:8]))
+		}
+	}
+	if diffs == 0 {
+		fmt.Fprintf(w, "\nNo differences\n")
+	}
+	fmt.Fprintf(w, "\n")
+	return diffs, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runDump prints a hexdump of a file of the image, or of a window of it, without
+// extracting it.
+func runDump(args []string) error {
+	fs := newFlagSet("dump")
+	f := addImageFlags(fs)
+	skip := fs.String("skip", "0", "Start this many bytes into the file")
+	length := fs.String("length", "", "Dump at most this many bytes (default: up to the end of the file)")
+	// the file name may come before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if name == "" && fs.NArg() == 1 {
+		name = fs.Arg(0)
+	} else if name == "" || fs.NArg() > 0 {
+		return argErrorf("dump requires exactly one file name")
+	}
+
+	start, err := strconv.ParseInt(*skip, 0, 64)
+	if err != nil || start < 0 {
+		return argErrorf("Invalid skip: %s", *skip)
+	}
+	limit := int64(-1)
+	if *length != "" {
+		if limit, err = strconv.ParseInt(*length, 0, 64); err != nil || limit < 0 {
+			return argErrorf("Invalid length: %s", *length)
+		}
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, err := img.Open(name)
+	if err != nil {
+		return err
+	}
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	var src io.Reader = r
+	if limit >= 0 {
+		src = io.LimitReader(r, limit)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err = hexDump(w, src, start); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// hexDump writes r in the format of hexdump -C: 16 bytes per line preceded by their
+// offset, counted from base, and followed by their printable characters. Runs of
+// identical lines are collapsed into a single "*".
+func hexDump(w io.Writer, r io.Reader, base int64) error {
+	line := make([]byte, 16)
+	var prev []byte
+	repeated := false
+	off := base
+	for {
+		n, err := io.ReadFull(r, line)
+		if n > 0 {
+			if n == len(line) && bytes.Equal(line, prev) {
+				if !repeated {
+					fmt.Fprintf(w, "*\n")
+					repeated = true
+				}
+			} else {
+				repeated = false
+				writeHexLine(w, off, line[:n])
+				prev = append(prev[:0], line[:n]...)
+			}
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%08x\n", off)
+	return err
+}
+
+func writeHexLine(w io.Writer, off int64, b []byte) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%08x ", off)
+	for i := 0; i < 16; i++ {
+		if i%8 == 0 {
+			sb.WriteByte(' ')
+		}
+		if i < len(b) {
+			fmt.Fprintf(&sb, "%02x ", b[i])
+		} else {
+			sb.WriteString("   ")
+		}
+	}
+	sb.WriteString(" |")
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteString("|\n")
+	io.WriteString(w, sb.String())
+}
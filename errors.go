@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// errDiffers is returned by diff and selftest when the images are not identical.
+var errDiffers = errors.New("images differ")
+
+// errAnomaly is returned for -strict when an image has a problem that is
+// otherwise only warned about.
+var errAnomaly = errors.New("strict")
+
+// argError reports invalid command line arguments.
+type argError struct {
+	msg string
+}
+
+func (e *argError) Error() string { return e.msg }
+
+func argErrorf(format string, v ...any) error {
+	return &argError{fmt.Sprintf(format, v...)}
+}
+
+// exitStatus ends the program with the given code once the reason has already
+// been reported, nothing else is logged for it.
+type exitStatus int
+
+func (e exitStatus) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// exitCode maps err to the exit code of the tool.
+func exitCode(err error) int {
+	var status exitStatus
+	var arg *argError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &status):
+		return int(status)
+	case errors.As(err, &arg):
+		return exitFailure
+	case errors.Is(err, sbfs.ErrNoHeader):
+		return exitNoHeader
+	case errors.Is(err, sbfs.ErrBadChecksum), errors.Is(err, sbfs.ErrNoChecksum):
+		return exitBadChecksum
+	case errors.Is(err, errDiffers):
+		return exitDiffers
+	case errors.Is(err, sbfs.ErrNoSpace), errors.Is(err, sbfs.ErrEmptySlot), errors.Is(err, sbfs.ErrUnknownFile), errors.Is(err, errAnomaly):
+		// the request cannot be carried out on this image
+		return exitFailure
+	}
+	// anything else comes from reading or writing files, out of bounds
+	// entries included
+	return exitIO
+}
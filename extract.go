@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// extracted describes a file written by extractFile.
+type extracted struct {
+	sum     []byte
+	trimmed int
+	err     error
+}
+
+// extractFiles writes files to x.out, up to x.jobs at a time, and returns the result
+// of each file by slot, leaving out the files not started after an error. Tar
+// archives are written sequentially, as is everything when progress is reported.
+func extractFiles(x *extractFlags, img *sbfs.Image, files []sbfs.FileInfo) map[int]extracted {
+	jobs := x.jobs
+	if _, ok := x.out.(dirOutput); !ok || x.progress || jobs < 1 {
+		jobs = 1
+	}
+	results := make([]extracted, len(files))
+	next := make(chan int)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < min(jobs, len(files)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = extractFile(x, img, files[i])
+				if results[i].err != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	// the first error ends the extraction, files not started yet are left out
+	sent := 0
+	for ; sent < len(files) && !failed.Load(); sent++ {
+		next <- sent
+	}
+	close(next)
+	wg.Wait()
+
+	bySlot := make(map[int]extracted, sent)
+	for i, f := range files[:sent] {
+		bySlot[f.Index] = results[i]
+	}
+	return bySlot
+}
+
+// extractFile writes the contents of f to x.out. It only reads through ReaderAt,
+// so several files can be extracted at once.
+func extractFile(x *extractFlags, img *sbfs.Image, f sbfs.FileInfo) extracted {
+	name := sbfs.FileName(f.Index)
+	// the raw fields show whether the block size interpretation fits the board
+	entry := img.Header.Header.Files[f.Index]
+	debugf(1, "reading %s: seek=0x%06X len=0x%06X (block offset field=0x%X, block length field=0x%X, block size 0x%X)",
+		name, f.Offset, f.Length, entry.Offset, entry.Length, img.Options().BlockSize)
+	var src io.Reader = img.Section(f)
+	var res extracted
+	exact := false
+	if x.trueLength != "" {
+		var n int64
+		if n, exact = img.UnknownLength(f); exact {
+			res.trimmed = int(f.Length - n)
+			src = io.NewSectionReader(img.Section(f), 0, n)
+		} else {
+			debugf(1, "%s: no true length in the file table (0x%X), keeping the block length", name, n)
+		}
+	}
+	// the true length leaves no padding to trim
+	if x.trim && !exact {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return extracted{err: fmt.Errorf("%s: %w", name, err)}
+		}
+		res.trimmed = paddingLen(data, int(img.Options().BlockSize)-1)
+		src = bytes.NewReader(data[:len(data)-res.trimmed])
+	}
+	length := f.Length - int64(res.trimmed)
+	fout, err := x.out.create(name, length)
+	if err != nil {
+		return extracted{err: err}
+	}
+	h := sha256.New()
+	var dst io.Writer = io.MultiWriter(fout, h)
+	var progress *progressWriter
+	if x.progress {
+		progress = &progressWriter{w: dst, out: os.Stderr, name: name, total: length}
+		dst = progress
+	}
+	n, err := io.Copy(dst, src)
+	if progress != nil {
+		progress.done()
+	}
+	if err = finishCopy(fout, n, length, err); err != nil {
+		return extracted{err: fmt.Errorf("%s: %w", name, err)}
+	}
+	res.sum = h.Sum(nil)
+	return res
+}
+
+// extractRange writes the bytes of rg to out as name and returns their SHA256.
+func extractRange(out extractOutput, name string, file io.ReaderAt, rg sbfs.Range) ([]byte, error) {
+	debugf(2, "copying %s: 0x%06X-0x%06X", name, rg.Start, rg.End)
+	fout, err := out.create(name, rg.End-rg.Start)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(fout, h), io.NewSectionReader(file, rg.Start, rg.End-rg.Start))
+	if err = finishCopy(fout, n, rg.End-rg.Start, err); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return h.Sum(nil), nil
+}
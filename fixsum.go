@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runFixsum recomputes the header checksum of an image whose header or files were
+// edited by other means and writes it back.
+func runFixsum(args []string) error {
+	fs := newFlagSet("fixsum")
+	f := addImageFlags(fs)
+	outputFile := fs.String("o", "", "output file (default: input file + .out). Using the input file keeps a .bak copy")
+	inPlace := fs.Bool("inplace", false, "Atomically replace the input file, keeping a .bak copy")
+	noBackup := fs.Bool("no-backup", false, "With -inplace, do not keep a .bak copy")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its header and checksum")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *inPlace && (isFlagPassed(fs, "o") || *f.input == "-") {
+		return argErrorf("-inplace cannot be used with -o or stdin")
+	}
+	if *f.input == "-" && !isFlagPassed(fs, "o") {
+		return argErrorf("-o is required when reading the image from stdin")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, ok := file.(memInput); ok && *inPlace {
+		return argErrorf("-inplace cannot be used with compressed images")
+	}
+
+	name := strings.ToUpper(opts.Checksum.Name())
+	old := img.Header.Checksum
+	if err = img.UpdateChecksum(); err != nil {
+		return fmt.Errorf("Cannot compute checksum: %w", err)
+	}
+	fmt.Printf("\n=== Fixing SBFS Checksum ===\n")
+	fmt.Printf("%20s: 0x%02X\n", "Old "+name+" checksum", old)
+	fmt.Printf("%20s: 0x%02X\n", "New "+name+" checksum", img.Header.Checksum)
+	if old == img.Header.Checksum {
+		fmt.Printf("\nChecksum is correct, nothing written\n")
+		fmt.Printf("\n")
+		return nil
+	}
+
+	outFileName := *f.input + ".out"
+	if isFlagPassed(fs, "o") {
+		outFileName = *outputFile
+	}
+	return writeImage(file, img, *f.input, outFileName, *inPlace, !*noBackup, !*noVerify)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// runFormat prints the on-disk layout of the header and file table entries, derived
+// from the structs used for parsing so that it always matches the code.
+func runFormat(args []string) error {
+	fs := newFlagSet("format")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return argErrorf("format takes no arguments")
+	}
+
+	entry := binary.Size(sbfs.File{})
+	fmt.Printf("\n=== SBFS Header ===\n")
+	fmt.Printf("Multi-byte fields are little-endian unless -endian big is given.\n\n")
+	fmt.Printf("%8s %8s  %-16s %s\n", "Offset", "Size", "Field", "Type")
+	off := printFields(reflect.TypeOf(sbfs.Header{}), 0, func(f reflect.StructField, off int) int {
+		// the file table, its length depends on the layout version
+		size := sbfs.NumFiles * entry
+		fmt.Printf("  0x%04X %8s  %-16s %s\n", off, fmt.Sprintf("%d*%d", sbfs.NumFiles, entry), f.Name, f.Type)
+		return size
+	})
+	var h sbfs.HeaderWithSha
+	fmt.Printf("  0x%04X %8d  %-16s %s\n", off, len(h.Checksum), "Checksum", reflect.TypeOf(h.Checksum))
+	fmt.Printf("\nOffsets past the file table assume %d slots, the default; see -layout-slots.\n", sbfs.NumFiles)
+
+	fmt.Printf("\n=== SBFS File Table Entry ===\n")
+	fmt.Printf("Offset and Length count blocks of -blocksize bytes, 0x%X by default.\n\n", sbfs.BlockSize)
+	fmt.Printf("%8s %8s  %-16s %s\n", "Offset", "Size", "Field", "Type")
+	printFields(reflect.TypeOf(sbfs.File{}), 0, nil)
+	fmt.Printf("\n")
+	return nil
+}
+
+// printFields prints a row for each field of struct type t, starting at off, and
+// returns the offset past the last one. Slices have no fixed size and are printed
+// by variable, which returns the size it assumed.
+func printFields(t reflect.Type, off int, variable func(f reflect.StructField, off int) int) int {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Slice {
+			off += variable(f, off)
+			continue
+		}
+		size := binary.Size(reflect.Zero(f.Type).Interface())
+		fmt.Printf("  0x%04X %8d  %-16s %s\n", off, size, f.Name, f.Type)
+		off += size
+	}
+	return off
+}
@@ -0,0 +1,3 @@
+module github.com/RetroTechCorner/sbfs-tool
+
+go 1.21
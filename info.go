@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// infoFlags are shared by info and extract.
+type infoFlags struct {
+	*imageFlags
+	json     *bool
+	csv      *bool
+	verify   *bool
+	raw      *bool
+	dumpHdr  *bool
+	color    *string
+	checkCRC *bool
+	quiet    *bool
+	human    *bool
+	strict   *bool
+}
+
+func addInfoFlags(fs *flag.FlagSet) *infoFlags {
+	return &infoFlags{
+		imageFlags: addImageFlags(fs),
+		json:       fs.Bool("json", false, "print header and file table as JSON"),
+		csv:        fs.Bool("csv", false, "print the file table as CSV: name, index, offset, length, sha256, empty"),
+		verify:     fs.Bool("verify", false, "verify the stored SHA256 checksum"),
+		raw:        fs.Bool("raw", false, "Also hex dump the unknown header and file table fields"),
+		color:      fs.String("color", "auto", "Highlight the tables: auto, always or never"),
+		dumpHdr:    fs.Bool("dumphdr", false, "Write the on-disk header bytes, checksum included, to <input>.rawhdr"),
+		human:      fs.Bool("human", false, "Append binary units, e.g. (64.0 KiB), to offsets and sizes"),
+		quiet:      fs.Bool("q", false, "Do not print the header and file table, warnings and -json output are still printed"),
+		strict:     fs.Bool("strict", false, "Fail on any anomaly otherwise only warned about: out of bounds or overlapping files, unknown versions, checksum mismatch, an image size that is not a whole number of blocks"),
+		checkCRC:   fs.Bool("check-file-crc", false, "Experimental: look for CRCs of each file in its unknown file table bytes"),
+	}
+}
+
+// tables reports whether the human readable tables go to stdout.
+func (f *infoFlags) tables() bool {
+	return !*f.json && !*f.csv && !*f.quiet
+}
+
+// options builds the parse options from the flags.
+func (f *infoFlags) options(fs *flag.FlagSet) (sbfs.Options, error) {
+	if *f.json && *f.csv {
+		return sbfs.Options{}, argErrorf("-json and -csv are mutually exclusive")
+	}
+	return f.imageFlags.options(fs)
+}
+
+// extractFlags select what extract writes and where.
+type extractFlags struct {
+	dir  string
+	tar  string
+	out  extractOutput
+	only stringList
+	// indexes are the slots selected by -index, along with those named by only
+	indexes []int
+	// include and exclude hold filepath.Match patterns
+	include stringList
+	exclude stringList
+	// noDataHdr skips writing data.hdr
+	noDataHdr bool
+	trim      bool
+	// trueLength is the source of the unpadded file lengths, "" for none
+	trueLength string
+	progress   bool
+	jobs       int
+	mode       os.FileMode
+	gaps       bool
+	maxSize    int64
+}
+
+// shouldExtract reports whether name passes the -only, -index, -include, -exclude
+// and -no-data-hdr filters.
+func (x *extractFlags) shouldExtract(name string) bool {
+	return x.selects(name, x.only.contains(name))
+}
+
+// shouldExtractFile is shouldExtract for the file in slot i, which -index selects by
+// its slot whatever its name.
+func (x *extractFlags) shouldExtractFile(i int) bool {
+	name := sbfs.FileName(i)
+	return x.selects(name, x.only.contains(name) || slices.Contains(x.indexes, i))
+}
+
+// selects applies the filters to name, picked tells whether -only or -index chose it.
+func (x *extractFlags) selects(name string, picked bool) bool {
+	if name == "data.hdr" && x.noDataHdr {
+		return false
+	}
+	if (len(x.only) > 0 || len(x.indexes) > 0) && !picked {
+		return false
+	}
+	if len(x.include) > 0 && !x.include.matches(name) {
+		return false
+	}
+	// exclude wins over include
+	return !x.exclude.matches(name)
+}
+
+// extracts reports whether the file fi of img is extracted: it is not empty, fits
+// the image and passes the filters.
+func (x *extractFlags) extracts(img *sbfs.Image, fi sbfs.FileInfo) bool {
+	return fi.Length != 0x00 && img.CheckBounds(fi) == nil && !x.tooLarge(fi) && x.shouldExtractFile(fi.Index)
+}
+
+// tooLarge reports whether f exceeds -max-file-size.
+func (x *extractFlags) tooLarge(f sbfs.FileInfo) bool {
+	return x.maxSize > 0 && f.Length > x.maxSize
+}
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info")
+	f := addInfoFlags(fs)
+	fs.Bool("list", false, "Same as info, kept for compatibility")
+	field := fs.String("field", "", "Print only the value of a header field: "+strings.Join(fieldNames, ", "))
+	scan := fs.Bool("scan", false, "Report every candidate header offset holding a valid magic with its sequence number and checksum status")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *field != "" && headerField(&sbfs.HeaderWithSha{}, *field) == "" {
+		return argErrorf("Unknown field: %s", *field)
+	}
+	// every bank would be dumped to the same file
+	if *f.dumpHdr && *f.bank == "all" {
+		return argErrorf("-dumphdr cannot be used with -bank all, select bank a or b")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	if *scan {
+		return scanHeaders(*f.input, opts)
+	}
+	file, banks, err := f.openBanks(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if *field != "" {
+		for _, img := range banks {
+			fmt.Println(headerField(&img.Header, *field))
+		}
+		return nil
+	}
+	// list every bank, the first failure decides the exit code
+	var first error
+	for i, img := range banks {
+		if len(banks) > 1 && f.tables() {
+			fmt.Printf("\n=== SBFS Bank %s ===\n", bankName(i))
+		}
+		if err := listImage(f, file, img, nil); first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// scanHeaders reports every candidate header offset of the named image that holds a
+// valid magic, without stopping at the first as parsing does.
+func scanHeaders(name string, opts sbfs.Options) error {
+	file, err := openInput(name)
+	if err != nil {
+		return fmt.Errorf("Error opening input file: %w", err)
+	}
+	defer file.Close()
+	imgs, err := sbfs.ScanHeaders(file, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if len(imgs) == 0 {
+		return fmt.Errorf("%s: %w", name, sbfs.ErrNoHeader)
+	}
+	for _, img := range imgs {
+		fmt.Printf("\n=== SBFS Header at 0x%06X ===\n", img.HeaderOffset)
+		fmt.Printf("%16s: 0x%02X\n", "Sequence", img.Header.Header.SequenceNumber)
+		printVerify(os.Stdout, img)
+	}
+	return nil
+}
+
+// fieldNames are the header fields accepted by -field.
+var fieldNames = []string{"sequence", "format", "layout", "unknown1", "sha"}
+
+// headerField formats the named header field as in the header table, or returns ""
+// for unknown names.
+func headerField(h *sbfs.HeaderWithSha, name string) string {
+	switch name {
+	case "sequence":
+		return fmt.Sprintf("0x%02X", h.Header.SequenceNumber)
+	case "format":
+		return fmt.Sprintf("0x%02X", h.Header.FormatVersion)
+	case "layout":
+		return fmt.Sprintf("0x%02X", h.Header.LayoutVersion)
+	case "unknown1":
+		return fmt.Sprintf("0x%02X", h.Header.Unknown1)
+	case "sha":
+		return fmt.Sprintf("0x%02X", h.Checksum)
+	}
+	return ""
+}
+
+func runExtract(args []string) error {
+	fs := newFlagSet("extract")
+	f := addInfoFlags(fs)
+	x := &extractFlags{}
+	fs.StringVar(&x.dir, "x", "", "output directory, auto names it extract_seqNN after the sequence number")
+	fs.StringVar(&x.dir, "dir", "", "Same as -x")
+	fs.StringVar(&x.tar, "tar", "", "Write the files to a tar archive instead of a directory")
+	fs.Var(&x.only, "only", "Extract only the named files (repeatable or comma-separated)")
+	fs.Var(&x.include, "include", "Extract only the files whose name matches one of these glob patterns (repeatable or comma-separated)")
+	fs.Var(&x.exclude, "exclude", "Do not extract the files whose name matches one of these glob patterns, even if included (repeatable or comma-separated)")
+	var indexes stringList
+	fs.Var(&indexes, "index", "Extract only the files in these slots, by index (repeatable or comma-separated)")
+	fs.BoolVar(&x.noDataHdr, "no-data-hdr", false, "Do not write data.hdr, the region before the SBFS header. pack needs it to rebuild the image")
+	fs.BoolVar(&x.trim, "trim", false, "Trim trailing 0x00/0xFF block padding from extracted files")
+	fs.StringVar(&x.trueLength, "true-length", "", "Take the exact length of each file from its file table entry: unknown0 reads the first 4 bytes of Unknown, falling back to the block length if zero or too large")
+	fs.BoolVar(&x.progress, "progress", false, "Report extraction progress on stderr, extracting one file at a time")
+	fs.IntVar(&x.jobs, "jobs", runtime.GOMAXPROCS(0), "Number of files to extract at once")
+	fs.BoolVar(&x.gaps, "extract-gaps", false, "Also write the regions past the header claimed by no file, as gap_0x<start>-0x<end>.bin")
+	maxSize := fs.String("max-file-size", "0x2000000", "Skip files larger than this many bytes, 0 for no limit")
+	force := fs.Bool("force", false, "Extract even if the format and layout versions are not known to be supported, implies -overwrite")
+	overwrite := fs.Bool("overwrite", false, "Replace files already present in the output directory")
+	mode := fs.String("mode", fmt.Sprintf("%04o", defaultMode), "Permission of the extracted files in octal, the output directory gets search permission to match")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if (x.dir == "") == (x.tar == "") {
+		return argErrorf("extract requires either an output directory (-x) or a tar archive (-tar)")
+	}
+	if x.trueLength != "" && x.trueLength != "unknown0" {
+		return argErrorf("Invalid true length source: %s", x.trueLength)
+	}
+	m, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || m > 0777 {
+		return argErrorf("Invalid mode: %s", *mode)
+	}
+	x.mode = os.FileMode(m)
+	if x.maxSize, err = strconv.ParseInt(*maxSize, 0, 64); err != nil || x.maxSize < 0 {
+		return argErrorf("Invalid max file size: %s", *maxSize)
+	}
+	for _, v := range indexes {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return argErrorf("Invalid slot index: %s", v)
+		}
+		x.indexes = append(x.indexes, i)
+	}
+	for _, pattern := range append(slices.Clone(x.include), x.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return argErrorf("Invalid pattern: %s", pattern)
+		}
+	}
+	// names are resolved once -names, possibly set by -config, is loaded
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	for _, name := range x.only {
+		if name != "data.hdr" && name != "sbfs.hdr" && sbfs.FileIndex(name) < 0 {
+			return argErrorf("Unknown file name: %s", name)
+		}
+	}
+
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !img.KnownVersion() && !*force {
+		h := img.Header.Header
+		return argErrorf("format 0x%02X, layout 0x%02X is not known to be supported, the files may be misread. Use -force to extract anyway", h.FormatVersion, h.LayoutVersion)
+	}
+	// -x auto names the directory after the sequence number, so extractions of
+	// different firmware versions do not end up in one directory
+	if x.dir == "auto" {
+		x.dir = fmt.Sprintf("extract_seq%02X", img.Header.Header.SequenceNumber)
+		fmt.Fprintf(os.Stderr, "Extracting to %s\n", x.dir)
+	}
+	if x.tar != "" {
+		out, err := newTarOutput(x.tar, x.mode)
+		if err != nil {
+			return err
+		}
+		x.out = out
+	} else {
+		// create output dir if needed
+		if _, err := os.Stat(x.dir); errors.Is(err, os.ErrNotExist) {
+			if err = os.Mkdir(x.dir, dirMode(x.mode)); err != nil {
+				return err
+			}
+		} else if !*overwrite && !*force {
+			var existing []string
+			for _, name := range outputNames(x, img) {
+				if _, err := os.Lstat(filepath.Join(x.dir, name)); err == nil {
+					existing = append(existing, name)
+				}
+			}
+			if len(existing) > 0 {
+				return argErrorf("%s already holds %s. Use -overwrite to replace them", x.dir, strings.Join(existing, ", "))
+			}
+		}
+		x.out = dirOutput{x.dir, x.mode}
+	}
+	return listImage(f, file, img, x)
+}
+
+// outputNames returns the names of the files extract writes for img.
+func outputNames(x *extractFlags, img *sbfs.Image) []string {
+	var names []string
+	for _, name := range []string{"data.hdr", "sbfs.hdr"} {
+		if x.shouldExtract(name) {
+			names = append(names, name)
+		}
+	}
+	for _, fi := range img.Files {
+		if x.extracts(img, fi) {
+			names = append(names, sbfs.FileName(fi.Index))
+		}
+	}
+	if x.gaps {
+		for _, g := range img.Unclaimed() {
+			names = append(names, gapName(g))
+		}
+	}
+	return append(names, "sha256sums.txt", "manifest.json")
+}
+
+// gapName returns the name a gap is extracted as with -extract-gaps.
+func gapName(g sbfs.Range) string {
+	return fmt.Sprintf("gap_0x%06X-0x%06X.bin", g.Start, g.End)
+}
+
+// listImage prints the header and file table of img and, unless x is nil,
+// extracts the files. A bad checksum or skipped files, which are reported along
+// the way, are returned as the matching exit status.
+func listImage(f *infoFlags, file io.ReaderAt, img *sbfs.Image, x *extractFlags) error {
+	var err error
+	header := &img.Header
+	opts := img.Options()
+	// an output left unfinished by an error is not worth keeping
+	finished := false
+	if x != nil {
+		defer func() {
+			if !finished {
+				x.out.abort()
+			}
+		}()
+	}
+
+	// colors are for the tables only
+	if err = setColor(*f.color); err != nil {
+		return err
+	}
+	if !f.tables() {
+		colorOutput = false
+	}
+	humanSizes = *f.human
+	// the checks requested by flags are reported on stderr when the tables are
+	// not printed, keeping stdout parseable in JSON mode
+	report := os.Stdout
+	if !f.tables() {
+		report = os.Stderr
+	}
+
+	if *f.strict {
+		if err = strictCheck(img); err != nil {
+			return err
+		}
+	}
+	if !img.KnownVersion() {
+		fmt.Fprintf(os.Stderr, "Warning: format 0x%02X, layout 0x%02X is not known to be supported, the file table may be misread\n", header.Header.FormatVersion, header.Header.LayoutVersion)
+	}
+	if f.tables() {
+		fmt.Printf("\n=== SBFS Header ===\n")
+		if img.MagicReversed {
+			fmt.Printf("%16s: %s (at offset: 0x%06X)\n", "Magic", green(displayMagic(img)), img.HeaderOffset)
+		} else {
+			fmt.Printf("%16s: %s (at offset: 0x%06X, stored in order)\n", "Magic", green(displayMagic(img)), img.HeaderOffset)
+		}
+		fmt.Printf("%16s: 0x%02X\n", "Format Version", header.Header.FormatVersion)
+		fmt.Printf("%16s: 0x%02X\n", "Sequence Number", header.Header.SequenceNumber)
+		fmt.Printf("%16s: 0x%02X\n", "Layout Version", header.Header.LayoutVersion)
+		fmt.Printf("%16s: 0x%02X\n", "SHA", header.Checksum)
+		if note := header.Header.Unknown1Note(); note != "" {
+			fmt.Printf("%16s: 0x%02X (%s)\n", "Unknown1", header.Header.Unknown1, note)
+		} else {
+			fmt.Printf("%16s: 0x%02X\n", "Unknown1", header.Header.Unknown1)
+		}
+		if *f.raw {
+			fmt.Printf("%16s: % X\n", "Unknown2", header.Header.Unknown2[:])
+		}
+	}
+	checksumOK := true
+	if *f.verify {
+		checksumOK = printVerify(report, img)
+	}
+
+	if *f.dumpHdr {
+		if err = dumpHeader(rawHeaderName(*f.input), img); err != nil {
+			return err
+		}
+	}
+
+	// machine-readable description of what was extracted
+	m := &manifest{jsonHeader: newJSONHeader(img), Files: []manifestFile{}}
+
+	// copy initial chunk of data
+	if x != nil && x.shouldExtract("data.hdr") {
+		// everything up to the header, which pack places right after it
+		base := opts.Base
+		size := img.HeaderOffset - base
+		var fout io.WriteCloser
+		fout, err = x.out.create("data.hdr", size)
+		if err != nil {
+			return err
+		}
+		debugf(2, "copying data.hdr: 0x%06X-0x%06X", base, base+size)
+		h := sha256.New()
+		var n int64
+		n, err = io.Copy(io.MultiWriter(fout, h), io.NewSectionReader(file, base, size))
+		if err = finishCopy(fout, n, size, err); err != nil {
+			return fmt.Errorf("data.hdr: %w", err)
+		}
+		m.Files = append(m.Files, manifestFile{Name: "data.hdr", Length: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	// raw header, used as template by pack
+	if x != nil && x.shouldExtract("sbfs.hdr") {
+		var fout io.WriteCloser
+		fout, err = x.out.create("sbfs.hdr", int64(img.Header.Size()))
+		if err != nil {
+			return err
+		}
+		debugf(2, "copying sbfs.hdr: 0x%06X-0x%06X", img.HeaderOffset, img.HeaderOffset+int64(img.Header.Size()))
+		h := sha256.New()
+		var n int64
+		n, err = io.Copy(io.MultiWriter(fout, h), img.HeaderSection())
+		if err = finishCopy(fout, n, int64(img.Header.Size()), err); err != nil {
+			return fmt.Errorf("sbfs.hdr: %w", err)
+		}
+		m.Files = append(m.Files, manifestFile{Name: "sbfs.hdr", Offset: img.HeaderOffset, Length: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+
+	// extract the files up front, possibly in parallel, then report them in order
+	var results map[int]extracted
+	if x != nil {
+		var todo []sbfs.FileInfo
+		for _, fi := range img.Files {
+			if x.extracts(img, fi) {
+				todo = append(todo, fi)
+			}
+		}
+		results = extractFiles(x, img, todo)
+	}
+
+	if f.tables() {
+		fmt.Printf("\n=== SBFS Files ===\n")
+	}
+	// sha256sum compatible manifest of the extracted files
+	sums := new(bytes.Buffer)
+	var skipped []string
+	for _, fi := range img.Files {
+		if fi.Length == 0x00 {
+			continue
+		}
+		line := fmt.Sprintf("%16s %10s:%s %10s:%s", sbfs.FileName(fi.Index), "Offset", hexSize(fi.Offset), "Length", hexSize(fi.Length))
+		if *f.raw {
+			line += fmt.Sprintf(" %10s:% X", "Unknown", header.Header.Files[fi.Index].Unknown[:])
+		}
+		if err = img.CheckBounds(fi); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v, skipping\n", sbfs.FileName(fi.Index), err)
+			skipped = append(skipped, sbfs.FileName(fi.Index))
+			if x != nil && x.shouldExtractFile(fi.Index) {
+				m.Files = append(m.Files, manifestFile{Name: sbfs.FileName(fi.Index), Offset: fi.Offset, Length: fi.Length, OutOfBounds: true})
+			}
+			if f.tables() {
+				fmt.Printf("%s %s\n", line, red("(out of bounds)"))
+			}
+			continue
+		}
+		if x != nil && x.tooLarge(fi) && x.shouldExtractFile(fi.Index) {
+			fmt.Fprintf(os.Stderr, "Warning: %s: length 0x%06X exceeds -max-file-size 0x%06X, skipping\n", sbfs.FileName(fi.Index), fi.Length, x.maxSize)
+			line += " " + red("(too large, not extracted)")
+		}
+		if res, ok := results[fi.Index]; ok {
+			if res.err != nil {
+				return res.err
+			}
+			line += fmt.Sprintf(" %10s:%x", "SHA256", res.sum)
+			if x.trim || x.trueLength != "" {
+				line += fmt.Sprintf(" %10s:0x%04X", "Trimmed", res.trimmed)
+			}
+			fmt.Fprintf(sums, "%x  %s\n", res.sum, sbfs.FileName(fi.Index))
+			m.Files = append(m.Files, manifestFile{
+				Name:    sbfs.FileName(fi.Index),
+				Offset:  fi.Offset,
+				Length:  fi.Length - int64(res.trimmed),
+				SHA256:  hex.EncodeToString(res.sum),
+				Trimmed: res.trimmed,
+			})
+		}
+		if f.tables() {
+			fmt.Println(line)
+		}
+	}
+	if x != nil && x.gaps {
+		if f.tables() {
+			fmt.Printf("\n=== SBFS Gaps ===\n")
+		}
+		for _, g := range img.Unclaimed() {
+			name := gapName(g)
+			sum, err := extractRange(x.out, name, file, g)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(sums, "%x  %s\n", sum, name)
+			m.Files = append(m.Files, manifestFile{Name: name, Offset: g.Start, Length: g.End - g.Start, SHA256: hex.EncodeToString(sum)})
+			if f.tables() {
+				fmt.Printf("%s %10s:%s %10s:%s\n", name, "Offset", hexSize(g.Start), "Length", hexSize(g.End-g.Start))
+			}
+		}
+	}
+	if f.tables() {
+		printUsage(os.Stdout, img)
+	}
+	if x != nil {
+		if err = writeOutput(x.out, "sha256sums.txt", sums.Bytes()); err != nil {
+			return err
+		}
+		if err = writeOutput(x.out, "manifest.json", manifestJSON(m)); err != nil {
+			return err
+		}
+		if err = x.out.close(); err != nil {
+			return err
+		}
+		finished = true
+	}
+	if *f.verify {
+		printLayout(report, img)
+	}
+	if *f.checkCRC {
+		if err = printFileCRCs(report, img); err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d out of bounds file(s): %s (image size 0x%06X, truncated dump?)\n", len(skipped), strings.Join(skipped, ", "), img.Size)
+	}
+	if *f.json {
+		if err = writeJSON(os.Stdout, img); err != nil {
+			return err
+		}
+	} else if *f.csv {
+		if err = writeCSV(os.Stdout, img); err != nil {
+			return err
+		}
+	} else if f.tables() {
+		fmt.Printf("\n")
+	}
+	if !checksumOK {
+		return exitStatus(exitBadChecksum)
+	}
+	if len(skipped) > 0 {
+		return exitStatus(exitIO)
+	}
+	return nil
+}
+
+// rawHeaderName returns the name -dumphdr writes to for input.
+func rawHeaderName(input string) string {
+	if input == "-" {
+		return "stdin.rawhdr"
+	}
+	return input + ".rawhdr"
+}
+
+// dumpHeader copies the header as found in the image to name, bypassing any
+// re-serialization of the parsed fields.
+func dumpHeader(name string, img *sbfs.Image) error {
+	fout, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	debugf(2, "copying raw header: 0x%06X-0x%06X", img.HeaderOffset, img.HeaderOffset+int64(img.Header.Size()))
+	if _, err = io.Copy(fout, img.HeaderSection()); err != nil {
+		return err
+	}
+	if err = fout.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Raw header written to: %s\n", name)
+	return nil
+}
+
+// paddingLen returns the length of the trailing run of 0x00 or 0xFF bytes in data,
+// capped at max. Lengths are rounded up to whole blocks so at most a block minus
+// one byte of padding can have been added to a file.
+func paddingLen(data []byte, max int) int {
+	if len(data) == 0 || (data[len(data)-1] != 0x00 && data[len(data)-1] != 0xFF) {
+		return 0
+	}
+	pad := data[len(data)-1]
+	n := 0
+	for n < max && n < len(data) && data[len(data)-1-n] == pad {
+		n++
+	}
+	return n
+}
+
+// humanSizes is set by -human to follow hex sizes with binary units.
+var humanSizes bool
+
+// hexSize formats an offset or size in hex, followed by its size in binary units
+// with -human.
+func hexSize(v int64) string {
+	if !humanSizes {
+		return fmt.Sprintf("0x%06X", v)
+	}
+	return fmt.Sprintf("0x%06X (%s)", v, humanSize(v))
+}
+
+// humanSize formats n bytes in the largest binary unit not exceeding it.
+func humanSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, unit := float64(n)/1024, 0
+	for v >= 1024 && unit < 3 {
+		v /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", v, []string{"KiB", "MiB", "GiB", "TiB"}[unit])
+}
+
+// printUsage summarizes how much of the image is taken by the header region and the files.
+func printUsage(w io.Writer, img *sbfs.Image) {
+	var used int64
+	var n int
+	for _, f := range img.Files {
+		if f.Length != 0x00 {
+			used += f.Length
+			n++
+		}
+	}
+	// everything up to the end of the SBFS header
+	header := img.HeaderOffset + int64(img.Header.Size())
+	fmt.Fprintf(w, "\n%16s: %s (%d files)\n", "Files Size", hexSize(used), n)
+	fmt.Fprintf(w, "%16s: %s\n", "Header Region", hexSize(header))
+	if img.Size < 0 {
+		return
+	}
+	fmt.Fprintf(w, "%16s: %s\n", "Image Size", hexSize(img.Size))
+	if free := img.Size - header - used; free >= 0 {
+		fmt.Fprintf(w, "%16s: %s (%.1f%%)\n", "Unused", hexSize(free), 100*float64(free)/float64(img.Size))
+	}
+}
+
+// strictCheck returns the anomalies of img that are otherwise only warned about, for
+// -strict. Gaps between files are normal and not reported.
+func strictCheck(img *sbfs.Image) error {
+	var errs []error
+	h := img.Header.Header
+	if !img.KnownVersion() {
+		errs = append(errs, fmt.Errorf("%w: format 0x%02X, layout 0x%02X is not known to be supported", errAnomaly, h.FormatVersion, h.LayoutVersion))
+	}
+	inBounds := true
+	for _, fi := range img.Files {
+		if fi.Length == 0x00 {
+			continue
+		}
+		if err := img.CheckBounds(fi); err != nil {
+			// exits as a truncated image, like the skipped files without -strict
+			errs = append(errs, fmt.Errorf("strict: %s: %w", sbfs.FileName(fi.Index), err))
+			inBounds = false
+		}
+	}
+	overlaps, _ := img.CheckLayout()
+	for _, o := range overlaps {
+		errs = append(errs, fmt.Errorf("%w: 0x%06X-0x%06X claimed by %s and %s", errAnomaly, o.Start, o.End, sbfs.FileName(o.A), sbfs.FileName(o.B)))
+	}
+	// a region within a larger dump has no known size
+	if o := img.Options(); o.Base == 0 && img.Size >= 0 && img.Size%o.BlockSize != 0 {
+		errs = append(errs, fmt.Errorf("%w: image size 0x%06X is not a multiple of the block size 0x%X", errAnomaly, img.Size, o.BlockSize))
+	}
+	// the full checksum scope cannot be computed over files past the end
+	if inBounds || img.Options().ChecksumScope != sbfs.ScopeFull {
+		if err := img.Verify(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: checksum: %w", errAnomaly, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// printLayout reports overlapping files and gaps between files.
+func printLayout(w io.Writer, img *sbfs.Image) {
+	overlaps, gaps := img.CheckLayout()
+	fmt.Fprintf(w, "\n=== SBFS Layout ===\n")
+	for _, o := range overlaps {
+		fmt.Fprintf(w, "%16s: 0x%06X-0x%06X claimed by %s and %s\n", "Overlap", o.Start, o.End, sbfs.FileName(o.A), sbfs.FileName(o.B))
+	}
+	for _, g := range gaps {
+		fmt.Fprintf(w, "%16s: 0x%06X-0x%06X (0x%06X bytes)\n", "Gap", g.Start, g.End, g.End-g.Start)
+	}
+	if len(overlaps) == 0 && len(gaps) == 0 {
+		fmt.Fprintf(w, "%16s: no overlaps or gaps\n", "OK")
+	}
+}
+
+// printVerify prints the result of checking the stored checksum and reports whether it is valid.
+func printVerify(w io.Writer, img *sbfs.Image) bool {
+	err := img.Verify()
+	switch {
+	case err == nil:
+		fmt.Fprintf(w, "%16s: %s\n", "Checksum", green("OK"))
+	case errors.Is(err, sbfs.ErrNoChecksum):
+		fmt.Fprintf(w, "%16s: %s\n", "Checksum", red("UNINITIALIZED (all zeros)"))
+	case errors.Is(err, sbfs.ErrBadChecksum):
+		sum, _ := img.ComputeChecksum(img.Options().ChecksumScope)
+		fmt.Fprintf(w, "%16s: %s\n", "Checksum", red(fmt.Sprintf("MISMATCH (computed: 0x%02X)", sum)))
+	default:
+		fmt.Fprintf(w, "%16s: %s\n", "Checksum", red(fmt.Sprintf("ERROR (%v)", err)))
+	}
+	return err == nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+func runInject(args []string) error {
+	fs := newFlagSet("inject")
+	f := addImageFlags(fs)
+	changeSequence := fs.String("s", "", "Change sequence number. Hex (0x..) or decimal value, +n/-n relative to the current one")
+	fs.StringVar(changeSequence, "seq", "", "Same as -s")
+	changeFormat := fs.String("format", "", "Change format version. Hex value required")
+	changeLayout := fs.String("layout", "", "Change layout version. Hex value required")
+	var replaceFiles stringList
+	fs.Var(&replaceFiles, "replace", "Replace file contents. Format: name=path, or path with -index (repeatable)")
+	deleteFile := fs.String("delete", "", "Clear the file table entry of the named file or slot index")
+	index := fs.Int("index", -1, "Slot index the -replace path is written to, for slots without a name")
+	outputFile := fs.String("o", "", "output file (default: input file + .out). Using the input file keeps a .bak copy")
+	inPlace := fs.Bool("inplace", false, "Atomically replace the input file, keeping a .bak copy")
+	noBackup := fs.Bool("no-backup", false, "With -inplace, do not keep a .bak copy")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its header and checksum")
+	force := fs.Bool("force", false, "Write the image even if nothing changed")
+	patchFile := fs.String("patch", "", "Write the changed bytes, located relative to the header, to this file instead of a full image (also writes the image with -o)")
+	var dryRun bool
+	fs.BoolVar(&dryRun, "n", false, "Show the changes without writing the output file")
+	fs.BoolVar(&dryRun, "dry-run", false, "Same as -n")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var newFormat, newLayout uint8
+	var seq seqChange
+	setSequence := isFlagPassed(fs, "s") || isFlagPassed(fs, "seq")
+	if setSequence {
+		var err error
+		if seq, err = parseSeqChange(*changeSequence); err != nil {
+			return argErrorf("Invalid sequence number: %v", err)
+		}
+	}
+	if isFlagPassed(fs, "format") {
+		if _, err := fmt.Sscanf(*changeFormat, "0x%x", &newFormat); err != nil {
+			return argErrorf("Invalid format version: %v", err)
+		}
+	}
+	if isFlagPassed(fs, "layout") {
+		if _, err := fmt.Sscanf(*changeLayout, "0x%x", &newLayout); err != nil {
+			return argErrorf("Invalid layout version: %v", err)
+		}
+	}
+	if !setSequence && !isFlagPassed(fs, "format") && !isFlagPassed(fs, "layout") && !isFlagPassed(fs, "replace") && !isFlagPassed(fs, "delete") {
+		return argErrorf("Nothing to inject, use -s, -format, -layout, -replace or -delete")
+	}
+	if *inPlace && (isFlagPassed(fs, "o") || *f.input == "-" || *patchFile != "") {
+		return argErrorf("-inplace cannot be used with -o, -patch or stdin")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	// names are resolved once -names, possibly set by -config, is loaded
+	var replacements []replacement
+	if isFlagPassed(fs, "index") {
+		if len(replaceFiles) != 1 || *index < 0 {
+			return argErrorf("-index requires a single -replace and a slot index from 0")
+		}
+		replacements = append(replacements, replacement{*index, replaceFiles[0]})
+	} else {
+		for _, arg := range replaceFiles {
+			name, path, ok := strings.Cut(arg, "=")
+			slot := parseSlot(name)
+			if !ok || slot < 0 {
+				return argErrorf("Invalid replace argument: %v", arg)
+			}
+			// two patches of the same slot would overlap when writing
+			for _, r := range replacements {
+				if r.slot == slot {
+					return argErrorf("%s is replaced more than once", sbfs.FileName(slot))
+				}
+			}
+			replacements = append(replacements, replacement{slot, path})
+		}
+	}
+	deleteSlot := parseSlot(*deleteFile)
+	if isFlagPassed(fs, "delete") && deleteSlot < 0 {
+		return argErrorf("Invalid delete argument: %v", *deleteFile)
+	}
+	if *f.input == "-" && !isFlagPassed(fs, "o") && *patchFile == "" && !dryRun {
+		return argErrorf("-o is required when reading the image from stdin")
+	}
+
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, ok := file.(memInput); ok && *inPlace {
+		return argErrorf("-inplace cannot be used with compressed images")
+	}
+	header := &img.Header
+	before := header.Encode(opts.ByteOrder)
+
+	fmt.Printf("\n=== Updating SBFS ===\n")
+
+	// modify header
+	if setSequence {
+		newSeq, err := seq.apply(header.Header.SequenceNumber)
+		if err != nil {
+			return argErrorf("Invalid sequence number: %v", err)
+		}
+		if newSeq == header.Header.SequenceNumber {
+			fmt.Fprintf(os.Stderr, "Warning: sequence number is already 0x%02X\n", newSeq)
+		}
+		if seq.relative {
+			fmt.Printf("%20s: 0x%02X -> 0x%02X\n", "New Sequence number", header.Header.SequenceNumber, newSeq)
+		} else {
+			fmt.Printf("%20s: 0x%02X\n", "New Sequence number", newSeq)
+		}
+		header.Header.SequenceNumber = newSeq
+	}
+	if isFlagPassed(fs, "format") {
+		fmt.Printf("%20s: 0x%02X -> 0x%02X\n", "New Format version", header.Header.FormatVersion, newFormat)
+		header.Header.FormatVersion = newFormat
+	}
+	if isFlagPassed(fs, "layout") {
+		fmt.Printf("%20s: 0x%02X -> 0x%02X\n", "New Layout version", header.Header.LayoutVersion, newLayout)
+		header.Header.LayoutVersion = newLayout
+	}
+	// every replacement is tried so that all the rejected ones are reported at once
+	var rejected []error
+	for _, r := range replacements {
+		if err := replaceSlot(img, r); err != nil {
+			rejected = append(rejected, fmt.Errorf("Cannot replace %s: %w", sbfs.FileName(r.slot), err))
+		}
+	}
+	if len(rejected) > 0 {
+		return errors.Join(rejected...)
+	}
+	if isFlagPassed(fs, "delete") {
+		i, name := deleteSlot, sbfs.FileName(deleteSlot)
+		if i >= len(img.Files) {
+			fmt.Fprintf(os.Stderr, "Warning: %s: no such slot in layout 0x%02X\n", name, header.Header.LayoutVersion)
+		} else if f := img.Files[i]; img.Delete(i) != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: slot is empty, nothing to delete\n", name)
+		} else {
+			fmt.Printf("%20s: %s (was Offset:0x%06X Length:0x%06X)\n", "Deleted", name, f.Offset, f.Length)
+		}
+	}
+	if err := img.UpdateChecksum(); err != nil {
+		return fmt.Errorf("Cannot compute checksum: %w", err)
+	}
+	fmt.Printf("%20s: 0x%02X\n", "New "+strings.ToUpper(opts.Checksum.Name())+" checksum", header.Checksum)
+
+	if dryRun {
+		fmt.Printf("\nDry run, nothing written\n")
+		fmt.Printf("\n")
+		return nil
+	}
+	// rewriting a whole image for nothing only costs time and flash wear
+	if !isFlagPassed(fs, "replace") && bytes.Equal(before, header.Encode(opts.ByteOrder)) && !*force {
+		fmt.Printf("\nNothing changed, not writing the image (use -force to write anyway)\n")
+		fmt.Printf("\n")
+		return nil
+	}
+
+	if *patchFile != "" {
+		if err = writePatch(*patchFile, file, img); err != nil {
+			return err
+		}
+		if !isFlagPassed(fs, "o") {
+			return nil
+		}
+	}
+
+	// write everything out
+	outFileName := *f.input + ".out"
+	if isFlagPassed(fs, "o") {
+		outFileName = *outputFile
+	}
+	return writeImage(file, img, *f.input, outFileName, *inPlace, !*noBackup, !*noVerify)
+}
+
+// replacement is a file to be written to a slot by -replace.
+type replacement struct {
+	slot int
+	path string
+}
+
+// replaceSlot replaces the contents of the slot of r with the file at r.path.
+func replaceSlot(img *sbfs.Image, r replacement) error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if r.slot >= len(img.Files) {
+		return fmt.Errorf("%w: no such slot in layout 0x%02X", sbfs.ErrUnknownFile, img.Header.Header.LayoutVersion)
+	}
+	if err = img.Replace(r.slot, data); errors.Is(err, sbfs.ErrEmptyData) {
+		return fmt.Errorf("%s: %w, use -delete to remove the file", r.path, err)
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("%20s: %s (0x%06X bytes, Length:0x%06X)\n", "Replaced", sbfs.FileName(r.slot), len(data), img.Files[r.slot].Length)
+	return nil
+}
+
+// writePatch writes the bytes of img that differ from the original in file to
+// name, see writeDelta, and prints a summary of them.
+func writePatch(name string, file input, img *sbfs.Image) error {
+	d := &diffWriter{orig: file}
+	if _, err := img.WriteTo(d); err != nil {
+		return err
+	}
+	fmt.Printf("\n=== SBFS Patch ===\n")
+	total := 0
+	for _, r := range d.runs {
+		fmt.Printf("%20s: 0x%06X-0x%06X (header%+#x, %d bytes)\n", "Changed", r.offset, r.offset+int64(len(r.data)), r.offset-img.HeaderOffset, len(r.data))
+		total += len(r.data)
+	}
+	if err := writeDelta(name, img.HeaderOffset, d.runs); err != nil {
+		return err
+	}
+	fmt.Printf("\nPatch written to: %s (%d runs, %d bytes changed)\n", name, len(d.runs), total)
+	fmt.Printf("\n")
+	return nil
+}
+
+// writeImage writes img, read from file named in, to out. With inPlace the input is
+// replaced atomically instead. Writing to the input file by name moves the original
+// aside as .bak first, as does inPlace unless backup is false. The headers of img
+// and of any other banks written along with it are verified afterwards if verify is
+// set.
+func writeImage(file input, img *sbfs.Image, in, out string, inPlace, backup, verify bool, banks ...*sbfs.Image) error {
+	banks = append([]*sbfs.Image{img}, banks...)
+	if inPlace {
+		written, err := writeInPlace(in, img, backup)
+		if err != nil {
+			return err
+		}
+		if img.Size >= 0 && written != img.Size {
+			return fmt.Errorf("Output size 0x%06X differs from input size 0x%06X", written, img.Size)
+		}
+		if backup {
+			fmt.Printf("%20s: %s\n", "Backup written to", in+".bak")
+		}
+		if verify {
+			if err = verifyBanks(in, banks); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("\nSBFS written to: %s\n", in)
+		fmt.Printf("\n")
+		return nil
+	}
+
+	// writing in place, move the original aside first. The open file keeps
+	// referring to the original contents so it can still be copied from.
+	if fi, err := os.Stat(out); err == nil {
+		if f, ok := file.(*os.File); ok && sameFile(f, fi) {
+			if err = os.Rename(in, in+".bak"); err != nil {
+				return err
+			}
+			fmt.Printf("%20s: %s\n", "Backup written to", in+".bak")
+		}
+	}
+	fout, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	written, err := img.WriteTo(fout)
+	if err != nil {
+		return err
+	}
+	if err = fout.Close(); err != nil {
+		return err
+	}
+	// only bytes are ever replaced in place, so the size must not change
+	if img.Size >= 0 && written != img.Size {
+		return fmt.Errorf("Output size 0x%06X differs from input size 0x%06X", written, img.Size)
+	}
+	if verify {
+		if err = verifyBanks(out, banks); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nSBFS written to: %s\n", out)
+	fmt.Printf("\n")
+	return nil
+}
+
+// verifyWritten parses the image written to name again, reading the header at offset
+// with opts, and checks that the header matches want, checksum included, and that
+// the checksum verifies. Every command writing an image calls it unless -no-verify
+// is given, to catch mistakes in how the image was put together.
+func verifyWritten(name string, opts sbfs.Options, offset int64, want *sbfs.HeaderWithSha) error {
+	opts.HeaderOffsets = []int64{offset}
+	opts.OnlyHeaderOffsets = true
+	opts.Log = nil
+	file, written, err := openImage(name, opts)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", name, err)
+	}
+	defer file.Close()
+	if !bytes.Equal(written.HeaderBytes(), want.EncodeWith(written.Options())) {
+		err = errors.New("header differs from the one written")
+	} else {
+		err = written.Verify()
+	}
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", name, err)
+	}
+	fmt.Printf("%20s: %s\n", "Verified", green("OK"))
+	return nil
+}
+
+// verifyBanks calls verifyWritten for the header of each of banks written to name.
+func verifyBanks(name string, banks []*sbfs.Image) error {
+	for _, b := range banks {
+		if err := verifyWritten(name, b.Options(), b.HeaderOffset, &b.Header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeInPlace replaces name with the contents of img without ever leaving a partly
+// written image behind: the image goes to a temporary file in the same directory
+// which is synced and then renamed over name. With backup the original is kept as
+// name.bak. The size check is left to the caller.
+func writeInPlace(name string, img *sbfs.Image, backup bool) (int64, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	// no-op once renamed
+	defer os.Remove(tmp.Name())
+
+	written, err := img.WriteTo(tmp)
+	if err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return written, err
+	}
+
+	if backup {
+		bak := name + ".bak"
+		if err = os.Remove(bak); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return written, err
+		}
+		// a hard link is free, file systems without them get a copy
+		if err = os.Link(name, bak); err != nil {
+			if err = copyFile(bak, name); err != nil {
+				return written, fmt.Errorf("keeping backup: %w", err)
+			}
+		}
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return written, err
+	}
+	// make the rename itself durable
+	if dir, err := os.Open(filepath.Dir(name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return written, nil
+}
+
+// copyFile copies src to a new file dst, synced to disk.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// parseByte parses a byte value given in hex with a 0x prefix or in decimal.
+func parseByte(s string) (uint8, error) {
+	v, err := strconv.ParseUint(s, 0, 8)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("%q out of range 0x00-0xFF", s)
+	} else if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return uint8(v), nil
+}
+
+// seqChange is a new sequence number, either absolute or relative to the current one.
+type seqChange struct {
+	value    int
+	relative bool
+}
+
+// parseSeqChange parses a -s value: a byte as accepted by parseByte, or one
+// prefixed with + or - to add to or subtract from the current sequence number.
+func parseSeqChange(s string) (seqChange, error) {
+	sign := 0
+	if strings.HasPrefix(s, "+") {
+		sign = 1
+	} else if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	if sign == 0 {
+		v, err := parseByte(s)
+		return seqChange{value: int(v)}, err
+	}
+	v, err := parseByte(s[1:])
+	return seqChange{value: sign * int(v), relative: true}, err
+}
+
+// apply returns the sequence number replacing cur, failing if a relative change
+// would leave the byte range instead of wrapping around.
+func (c seqChange) apply(cur uint8) (uint8, error) {
+	if !c.relative {
+		return uint8(c.value), nil
+	}
+	v := int(cur) + c.value
+	if v < 0x00 || v > 0xFF {
+		return 0, fmt.Errorf("0x%02X%+d is out of range 0x00-0xFF", cur, c.value)
+	}
+	return uint8(v), nil
+}
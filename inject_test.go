@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+func TestParseByte(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint8
+		ok   bool
+	}{
+		{"0x00", 0x00, true},
+		{"0xFF", 0xFF, true},
+		{"0xff", 0xFF, true},
+		{"255", 0xFF, true},
+		{"0x100", 0, false},
+		{"256", 0, false},
+		{"", 0, false},
+		{"FF", 0, false},
+		{"0x10garbage", 0, false},
+		{"-1", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseByte(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseByte(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByte(%q) = 0x%02X, want 0x%02X", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSeqChange(t *testing.T) {
+	tests := []struct {
+		in   string
+		cur  uint8
+		want uint8
+		ok   bool
+	}{
+		{"0x10", 0x07, 0x10, true},
+		{"+1", 0x07, 0x08, true},
+		{"+0x01", 0xFE, 0xFF, true},
+		{"+1", 0xFF, 0, false},
+		{"-1", 0x01, 0x00, true},
+		{"-2", 0x01, 0, false},
+		{"+", 0x07, 0, false},
+	}
+	for _, tt := range tests {
+		c, err := parseSeqChange(tt.in)
+		var got uint8
+		if err == nil {
+			got, err = c.apply(tt.cur)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%q applied to 0x%02X: error = %v, want ok %v", tt.in, tt.cur, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q applied to 0x%02X = 0x%02X, want 0x%02X", tt.in, tt.cur, got, tt.want)
+		}
+	}
+}
+
+// TestInjectPreservesNonHeaderBytes runs inject on a synthetic image with its header
+// at the second default offset and checks that only the sequence number and the
+// checksum change.
+func TestInjectPreservesNonHeaderBytes(t *testing.T) {
+	// recognizable, position dependent bytes everywhere but in the header, so that
+	// shifted data shows up as well as overwritten data
+	pattern := func(start, n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte((start + i) * 7)
+		}
+		return b
+	}
+	const headerOffset = 0x11000
+	orig := packTestImage(t, pattern(0, headerOffset), map[int][]byte{0: pattern(0, 0x2000), 3: pattern(0x3000, 0x1000)}, nil)
+	orig = append(orig, pattern(len(orig), 0x4000)...)
+
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.img"), filepath.Join(dir, "out.img")
+	if err := os.WriteFile(in, orig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runInject([]string{"-f", in, "-s", "+1", "-o", out}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(orig) {
+		t.Fatalf("output is 0x%X bytes, want 0x%X", len(got), len(orig))
+	}
+
+	headerEnd := headerOffset + sbfs.DefaultHeaderSize
+	if !bytes.Equal(got[:headerOffset], orig[:headerOffset]) {
+		t.Error("bytes before the header changed")
+	}
+	if !bytes.Equal(got[headerEnd:], orig[headerEnd:]) {
+		t.Error("bytes after the header changed")
+	}
+	const seq = headerOffset + 5
+	sum := headerEnd - sbfs.ChecksumSize
+	for i := headerOffset; i < headerEnd; i++ {
+		if got[i] != orig[i] && i != seq && i < sum {
+			t.Errorf("header byte 0x%X changed: 0x%02X -> 0x%02X", i, orig[i], got[i])
+		}
+	}
+	if got[seq] != 0x08 {
+		t.Errorf("sequence number = 0x%02X, want 0x08", got[seq])
+	}
+	if bytes.Equal(got[sum:headerEnd], orig[sum:headerEnd]) {
+		t.Error("checksum not updated")
+	}
+}
+
+func TestInjectMultipleReplace(t *testing.T) {
+	image := packTestImage(t, make([]byte, sbfs.NorHeaderSize), map[int][]byte{
+		0: bytes.Repeat([]byte{0xA0}, 0x2000),
+		3: bytes.Repeat([]byte{0xA3}, 0x1000),
+		7: bytes.Repeat([]byte{0xA7}, 0x1000),
+	}, nil)
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	in := write("in.img", image)
+	small := write("small.bin", []byte("new contents"))
+	big := write("big.bin", make([]byte, 0x2000))
+
+	out := filepath.Join(dir, "out.img")
+	if err := runInject([]string{"-f", in, "-replace", "smcfw.bin=" + small, "-replace", "smcerr.log=" + small, "-o", out}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := sbfs.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"smcfw.bin", "smcerr.log"} {
+		r, err := img.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len("new contents"))
+		if _, err = r.Read(got); err != nil || string(got) != "new contents" {
+			t.Errorf("%s starts with %q, err = %v", name, got, err)
+		}
+	}
+
+	// one replacement does not fit: nothing is written
+	rejected := filepath.Join(dir, "rejected.img")
+	err = runInject([]string{"-f", in, "-replace", "smcfw.bin=" + small, "-replace", "smcerr.log=" + big, "-o", rejected})
+	if !errors.Is(err, sbfs.ErrNoSpace) {
+		t.Fatalf("err = %v, want ErrNoSpace", err)
+	}
+	if _, err = os.Stat(rejected); !os.IsNotExist(err) {
+		t.Errorf("output written despite the rejected replacement: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+type jsonFile struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+	Unknown string `json:"unknown"`
+}
+
+type jsonHeader struct {
+	Magic          string `json:"magic"`
+	MagicReversed  bool   `json:"magicReversed"`
+	HeaderOffset   int64  `json:"headerOffset"`
+	FormatVersion  byte   `json:"formatVersion"`
+	SequenceNumber byte   `json:"sequenceNumber"`
+	LayoutVersion  byte   `json:"layoutVersion"`
+	Unknown1       byte   `json:"unknown1"`
+	Unknown1Note   string `json:"unknown1Note,omitempty"`
+	SHA256         string `json:"sha256"`
+}
+
+type jsonImage struct {
+	jsonHeader
+	Files []jsonFile `json:"files"`
+}
+
+// manifestFile describes a file written by extract. Out of bounds files are listed
+// without being extracted.
+type manifestFile struct {
+	Name        string `json:"name"`
+	Offset      int64  `json:"offset"`
+	Length      int64  `json:"length"`
+	SHA256      string `json:"sha256,omitempty"`
+	Trimmed     int    `json:"trimmed,omitempty"`
+	OutOfBounds bool   `json:"outOfBounds"`
+}
+
+// manifest is written to manifest.json by extract.
+type manifest struct {
+	jsonHeader
+	Files []manifestFile `json:"files"`
+}
+
+func newJSONHeader(img *sbfs.Image) jsonHeader {
+	header := &img.Header
+	return jsonHeader{
+		Magic:          displayMagic(img),
+		MagicReversed:  img.MagicReversed,
+		HeaderOffset:   img.HeaderOffset,
+		FormatVersion:  header.Header.FormatVersion,
+		SequenceNumber: header.Header.SequenceNumber,
+		LayoutVersion:  header.Header.LayoutVersion,
+		Unknown1:       header.Header.Unknown1,
+		Unknown1Note:   header.Header.Unknown1Note(),
+		SHA256:         hex.EncodeToString(header.Checksum[:]),
+	}
+}
+
+// manifestJSON returns m as indented JSON.
+func manifestJSON(m *manifest) []byte {
+	// marshaling plain structs cannot fail
+	data, _ := json.MarshalIndent(m, "", "  ")
+	return append(data, '\n')
+}
+
+// writeJSON prints the header and the non-empty entries of the file table as JSON.
+func writeJSON(w io.Writer, img *sbfs.Image) error {
+	header := &img.Header
+	out := jsonImage{
+		jsonHeader: newJSONHeader(img),
+		Files:      []jsonFile{},
+	}
+	for _, f := range img.Files {
+		// empty slots are omitted
+		if f.Length == 0x00 {
+			continue
+		}
+		out.Files = append(out.Files, jsonFile{
+			Index:   f.Index,
+			Name:    sbfs.FileName(f.Index),
+			Offset:  f.Offset,
+			Length:  f.Length,
+			Unknown: hex.EncodeToString(header.Header.Files[f.Index].Unknown[:]),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenImage packs a small synthetic image exercising the header fields, empty
+// slots and the unknown bytes of the file table.
+func goldenImage(t *testing.T) *sbfs.Image {
+	t.Helper()
+	data := packTestImage(t, make([]byte, sbfs.NorHeaderSize), map[int][]byte{
+		0: bytes.Repeat([]byte{0xA0}, 0x1800),
+		3: []byte("smcerr"),
+		7: bytes.Repeat([]byte{0xA7}, 0x2000),
+	}, func(h *sbfs.Header) {
+		h.Unknown1 = 0x03
+		h.Files[0].Unknown = [8]byte{0xDE, 0xAD, 0xBE, 0xEF}
+		h.Files[3].Unknown = [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	})
+	img, err := sbfs.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// TestJSONGolden locks down what is parsed from an image. When the understanding of
+// the format changes, regenerate the golden file with go test -run JSONGolden -update
+// and review the diff.
+func TestJSONGolden(t *testing.T) {
+	var got bytes.Buffer
+	if err := writeJSON(&got, goldenImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "image.golden.json")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("JSON differs from %s:\n%s\nwant:\n%s", golden, got.Bytes(), want)
+	}
+}
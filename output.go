@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// extractOutput receives the files written by extract.
+type extractOutput interface {
+	// create starts a new file of the given size
+	create(name string, size int64) (io.WriteCloser, error)
+	// close completes the output
+	close() error
+	// abort gives up on an output that cannot be completed
+	abort()
+}
+
+// writeOutput stores data as the file name of out.
+func writeOutput(out extractOutput, name string, data []byte) error {
+	w, err := out.create(name, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// finishCopy closes w once n bytes were copied to it with the given error. A copy
+// that ended before want bytes is reported as io.ErrUnexpectedEOF, the first error
+// is returned.
+func finishCopy(w io.Closer, n, want int64, err error) error {
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != want {
+		err = fmt.Errorf("%w: copied 0x%06X of 0x%06X bytes", io.ErrUnexpectedEOF, n, want)
+	}
+	return err
+}
+
+// defaultMode is the permission of extracted files unless -mode says otherwise.
+const defaultMode os.FileMode = 0644
+
+// dirMode returns the permission of a directory holding files of mode: every class
+// that may read the files may also search the directory.
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// dirOutput writes loose files with permission mode into a directory.
+type dirOutput struct {
+	dir  string
+	mode os.FileMode
+}
+
+func (d dirOutput) create(name string, size int64) (io.WriteCloser, error) {
+	return os.OpenFile(filepath.Join(d.dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, d.mode)
+}
+
+func (dirOutput) close() error { return nil }
+
+// abort keeps the files written so far, they are complete on their own.
+func (dirOutput) abort() {}
+
+// tarOutput streams the files into a tar archive.
+type tarOutput struct {
+	f       *os.File
+	tw      *tar.Writer
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// newTarOutput creates the archive name, the archive and its entries get permission mode.
+func newTarOutput(name string, mode os.FileMode) (*tarOutput, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &tarOutput{f: f, tw: tar.NewWriter(f), mode: mode, modTime: time.Now()}, nil
+}
+
+// tarEntry is the writer for the current entry, closing it does not close the archive.
+type tarEntry struct {
+	io.Writer
+}
+
+func (tarEntry) Close() error { return nil }
+
+func (t *tarOutput) create(name string, size int64) (io.WriteCloser, error) {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(t.mode.Perm()),
+		ModTime:  t.modTime,
+		Format:   tar.FormatUSTAR,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tarEntry{t.tw}, nil
+}
+
+func (t *tarOutput) close() error {
+	err := t.tw.Close()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// abort closes and removes the archive, which would be truncated.
+func (t *tarOutput) abort() {
+	t.f.Close()
+	os.Remove(t.f.Name())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+func runPack(args []string) error {
+	fs := newFlagSet("pack")
+	f := addLayoutFlags(fs)
+	dir := fs.String("dir", "", "directory created by extract")
+	fs.StringVar(dir, "pack", "", "Same as -dir")
+	outputFile := fs.String("o", "", "output file")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its header and checksum")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return argErrorf("pack requires a directory (-dir)")
+	}
+	if *outputFile == "" {
+		return argErrorf("pack requires -o")
+	}
+	opts, err := f.options()
+	if err != nil {
+		return err
+	}
+	return packImage(*dir, *outputFile, opts, !*noVerify)
+}
+
+// packImage builds an image from the contents of dir as written by extract: data.hdr,
+// the optional sbfs.hdr header template and the files named after their slots. With
+// verify the written image is parsed again and checked.
+func packImage(dir, outFileName string, opts sbfs.Options, verify bool) error {
+	// pack in memory so a failure leaves no partly written output behind
+	var buf bytes.Buffer
+	header, offset, err := packDir(&buf, dir, opts)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(outFileName, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	bs := opts.BlockSize
+	fmt.Printf("\n=== Packing SBFS ===\n")
+	for i, f := range header.Header.Files {
+		if f.Length == 0x00 {
+			continue
+		}
+		fmt.Printf("%16s %10s:0x%06X %10s:0x%06X\n", sbfs.FileName(i), "Offset", int64(f.Offset)*bs, "Length", int64(f.Length)*bs)
+	}
+	fmt.Printf("%16s: 0x%02X\n", "SHA256 checksum", header.Checksum)
+	if verify {
+		if err = verifyWritten(outFileName, opts, offset, header); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("\nSBFS written to: %s\n", outFileName)
+	fmt.Printf("\n")
+	return nil
+}
+
+// packDir reads the parts of an image from dir and packs them into w. It returns the
+// header written and its offset.
+func packDir(w io.Writer, dir string, opts sbfs.Options) (*sbfs.HeaderWithSha, int64, error) {
+	pre, err := os.ReadFile(filepath.Join(dir, "data.hdr"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%w (extractions made with -no-data-hdr cannot be packed)", err)
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	// without sbfs.hdr the versions are those of the first known version, which
+	// info and extract accept
+	known := sbfs.KnownVersions[0]
+	tmpl := &sbfs.HeaderWithSha{Header: sbfs.Header{
+		FormatVersion: known.Format,
+		LayoutVersion: known.Layout,
+		Files:         make([]sbfs.File, sbfs.NumFiles),
+	}}
+	raw, err := os.ReadFile(filepath.Join(dir, "sbfs.hdr"))
+	if err == nil {
+		if tmpl, err = sbfs.ReadHeader(bytes.NewReader(raw), opts); err != nil {
+			return nil, 0, fmt.Errorf("invalid sbfs.hdr: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, 0, err
+	}
+
+	files := make([][]byte, len(tmpl.Header.Files))
+	for i := range files {
+		data, err := os.ReadFile(filepath.Join(dir, sbfs.FileName(i)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+		files[i] = data
+	}
+	header, err := sbfs.Pack(w, pre, tmpl.Header, files, opts)
+	return header, int64(len(pre)), err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// TestPackMissingDataHdr checks that a failed pack leaves an existing output alone.
+func TestPackMissingDataHdr(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.img")
+	if err := os.WriteFile(out, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := packImage(dir, out, sbfs.Options{}, true); err == nil {
+		t.Fatal("packImage() succeeded without data.hdr")
+	}
+	if got, _ := os.ReadFile(out); string(got) != "keep" {
+		t.Errorf("output = %q, want it untouched", got)
+	}
+}
+
+// TestPackWithoutTemplate checks that a directory without sbfs.hdr packs into an
+// image of a known version.
+func TestPackWithoutTemplate(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"data.hdr":    make([]byte, 0x10000),
+		"file_00.bin": bytes.Repeat([]byte{0xA0}, 0x1000),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out.img")
+	if err := packImage(dir, out, sbfs.Options{}, true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := sbfs.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !img.KnownVersion() {
+		t.Errorf("version 0x%02X/0x%02X is not known", img.Header.Header.FormatVersion, img.Header.Header.LayoutVersion)
+	}
+}
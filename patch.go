@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// runPatch writes raw bytes into the header at a header-relative offset and updates
+// the checksum, for experimenting with the unknown fields.
+func runPatch(args []string) error {
+	fs := newFlagSet("patch")
+	f := addImageFlags(fs)
+	at := fs.String("at", "", "Header-relative offset to write at, the checksum cannot be patched")
+	data := fs.String("bytes", "", "Comma-separated bytes to write, e.g. 01,02,ff")
+	outputFile := fs.String("o", "", "output file (default: input file + .out). Using the input file keeps a .bak copy")
+	inPlace := fs.Bool("inplace", false, "Atomically replace the input file, keeping a .bak copy")
+	noBackup := fs.Bool("no-backup", false, "With -inplace, do not keep a .bak copy")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its header and checksum")
+	var dryRun bool
+	fs.BoolVar(&dryRun, "n", false, "Show the changes without writing the output file")
+	fs.BoolVar(&dryRun, "dry-run", false, "Same as -n")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	off, err := strconv.ParseInt(*at, 0, 32)
+	if err != nil || off < 0 {
+		return argErrorf("Invalid offset: %s", *at)
+	}
+	patch, err := parseBytes(*data)
+	if err != nil {
+		return argErrorf("Invalid bytes: %v", err)
+	}
+	if *inPlace && (isFlagPassed(fs, "o") || *f.input == "-") {
+		return argErrorf("-inplace cannot be used with -o or stdin")
+	}
+	if *f.input == "-" && !isFlagPassed(fs, "o") && !dryRun {
+		return argErrorf("-o is required when reading the image from stdin")
+	}
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, ok := file.(memInput); ok && *inPlace {
+		return argErrorf("-inplace cannot be used with compressed images")
+	}
+
+	old := img.HeaderBytes()
+	if err = img.PatchHeader(int(off), patch); err != nil {
+		return argErrorf("Cannot patch header: %v", err)
+	}
+	fmt.Printf("\n=== Patching SBFS Header ===\n")
+	fmt.Printf("%20s: 0x%02X-0x%02X (image offset 0x%06X)\n", "Patched", off, off+int64(len(patch)), img.HeaderOffset+off)
+	fmt.Printf("%20s: % X\n", "Old bytes", old[off:off+int64(len(patch))])
+	fmt.Printf("%20s: % X\n", "New bytes", patch)
+	if err = img.UpdateChecksum(); err != nil {
+		return fmt.Errorf("Cannot compute checksum: %w", err)
+	}
+	fmt.Printf("%20s: 0x%02X\n", "New "+strings.ToUpper(opts.Checksum.Name())+" checksum", img.Header.Checksum)
+
+	if dryRun {
+		fmt.Printf("\nDry run, nothing written\n")
+		fmt.Printf("\n")
+		return nil
+	}
+	outFileName := *f.input + ".out"
+	if isFlagPassed(fs, "o") {
+		outFileName = *outputFile
+	}
+	return writeImage(file, img, *f.input, outFileName, *inPlace, !*noBackup, !*noVerify)
+}
+
+// parseBytes parses a comma-separated list of hex bytes, with or without 0x prefix.
+func parseBytes(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("no bytes given")
+	}
+	var b []byte
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "0x")
+		n, err := strconv.ParseUint(v, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a hex byte", v)
+		}
+		b = append(b, byte(n))
+	}
+	return b, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// progressWriter counts the bytes written through it and reports them on out.
+type progressWriter struct {
+	w     io.Writer
+	out   io.Writer
+	name  string
+	total int64
+	n     int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	fmt.Fprintf(p.out, "\r%16s: 0x%06X / 0x%06X", p.name, p.n, p.total)
+	return n, err
+}
+
+// done terminates the progress line.
+func (p *progressWriter) done() {
+	fmt.Fprintf(p.out, "\n")
+}
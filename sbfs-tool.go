@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"flag"
@@ -11,65 +11,166 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
 )
 
+// exit codes
 const (
-	SBFS_NUM_FILES          = 12
-	SBFS_NUM_HEADER_OFFSETS = 2
-	// initial 0x10000 bytes of the dump contains some data that is not part of SBFS
-	NOR_HEADER_SIZE = 0x010000
+	exitOK          = 0
+	exitFailure     = 1 // invalid arguments and other errors
+	exitNoHeader    = 2
+	exitBadChecksum = 3
+	exitIO          = 4 // includes truncated images
+	exitDiffers     = 5 // diff found differences
 )
 
-var (
-	// flags
-	inputFile      = flag.String("f", "sbfs.img", "input file")
-	outputDir      = flag.String("x", "", "output directory")
-	changeSequence = flag.String("s", "", "Change sequence number. Hex value required")
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
 
-	// SBFS file names
-	sbfsFileNames = []string{
-		"smcfw.bin",
-		"psp1sp.bin",
-		"speaker.bin",
-		"smcerr.log",
-		"smc_d.cfg",
-		"certkeys.smc",
+var commands = []command{
+	{"info", "print the header and file table", runInfo},
+	{"extract", "print the header and file table and extract the files", runExtract},
+	{"active", "report the bank of an A/B image the device boots from", runActive},
+	{"inject", "modify the header or files and write a new image", runInject},
+	{"format", "print the on-disk layout of the header and file table", runFormat},
+	{"checksum", "print the stored and recomputed header checksum", runChecksum},
+	{"fixsum", "recompute the header checksum of an edited image", runFixsum},
+	{"patch", "write raw bytes into the header and update the checksum", runPatch},
+	{"pack", "build an image from an extracted directory", runPack},
+	{"diff", "compare two images", runDiff},
+	{"dump", "print a hexdump of a file of the image", runDump},
+	{"swap-banks", "make the other bank of an A/B image the active one", runSwapBanks},
+	{"canonicalize", "zero unused bytes and padding for byte-identical images", runCanonicalize},
+	{"selftest", "check that extracting and packing reproduces the image", runSelftest},
+}
+
+// legacyModes maps the mode flags of the flat command line used before
+// subcommands existed to the command handling them.
+var legacyModes = map[string]string{
+	"list":    "info",
+	"x":       "extract",
+	"tar":     "extract",
+	"s":       "inject",
+	"format":  "inject",
+	"layout":  "inject",
+	"replace": "inject",
+	"delete":  "inject",
+	"pack":    "pack",
+	"diff":    "diff",
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sbfs-tool <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun 'sbfs-tool <command> -h' for the flags of a command.\n")
+	fmt.Fprintf(os.Stderr, "Without a command the flags select the mode as in earlier versions.\n")
+}
 
-	// potential header offsets
-	sbfsHeaderOffsets = []int64{
-		0x10000,
-		0x11000,
+// legacyCommand picks the command for a flat invocation from the mode flags it uses.
+func legacyCommand(args []string) (string, error) {
+	var found []string
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if cmd, ok := legacyModes[name]; ok && !slices.Contains(found, cmd) {
+			found = append(found, cmd)
+		}
+	}
+	if len(found) > 1 {
+		return "", argErrorf("Conflicting modes: %s", strings.Join(found, ", "))
 	}
+	if len(found) == 0 {
+		return "info", nil
+	}
+	return found[0], nil
+}
 
-	// magic string
-	sbfsMagic = "SFBS"
-)
+// stringList is a flag.Value collecting repeated and comma-separated values.
+type stringList []string
 
-type sfbsFile struct {
-	Offset  uint32
-	Length  uint32
-	Unknown [8]byte
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-type sbfsHeader struct {
-	Magic          [4]byte
-	FormatVersion  byte
-	SequenceNumber byte
-	LayoutVersion  byte
-	Unknown1       byte
-	Unknown2       [24]byte
-	Files          [SBFS_NUM_FILES]sfbsFile
+func (l *stringList) Set(value string) error {
+	*l = append(*l, strings.Split(value, ",")...)
+	return nil
 }
 
-type sbfsHeaderWithSha struct {
-	Header   sbfsHeader
-	Checksum [32]byte
+// contains reports whether name is in the list.
+func (l stringList) contains(name string) bool {
+	return slices.Contains(l, name)
 }
 
-func isFlagPassed(name string) bool {
+// matches reports whether name matches one of the filepath.Match patterns in the list.
+func (l stringList) matches(name string) bool {
+	for _, pattern := range l {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSlot returns the file table slot given by s, a file name or a slot index,
+// or -1 if s is neither.
+func parseSlot(s string) int {
+	if i, err := strconv.Atoi(s); err == nil {
+		return max(i, -1)
+	}
+	return sbfs.FileIndex(s)
+}
+
+// newFlagSet returns the flag set of command name. Parse errors are returned by
+// parseFlags rather than exiting with code 2, which is reserved for images without a
+// valid header.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// parseFlags parses args into fs. The flag package has already reported a parse
+// error along with the usage, so it only leaves the exit status: exitFailure, or
+// exitOK for -h.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitStatus(exitOK)
+	} else if err != nil {
+		return exitStatus(exitFailure)
+	}
+	return nil
+}
+
+// parseHex parses a hex value given with a 0x prefix, nothing may follow the digits.
+func parseHex(s string) (int64, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return 0, fmt.Errorf("%q lacks the 0x prefix", s)
+	}
+	v, err := strconv.ParseInt(digits, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a hex value", s)
+	}
+	return v, nil
+}
+
+func isFlagPassed(fs *flag.FlagSet, name string) bool {
 	found := false
-	flag.Visit(func(f *flag.Flag) {
+	fs.Visit(func(f *flag.Flag) {
 		if f.Name == name {
 			found = true
 		}
@@ -77,167 +178,426 @@ func isFlagPassed(name string) bool {
 	return found
 }
 
-func reverseString(str string) (result string) {
-	// iterate over str and prepend to result
-	for _, v := range str {
-		result = string(v) + result
-	}
-	return
+// layoutFlags describe how an image is laid out.
+type layoutFlags struct {
+	fs          *flag.FlagSet
+	config      *string
+	blockSize   *string
+	headerSize  *string
+	checksum    *string
+	scope       *string
+	checksumPos *string
+	layoutSlots stringList
+	endian      *string
+	names       *string
+	magic       *string
+	verbose     *int
 }
 
-func main() {
-	flag.Parse()
-	var newSeq uint8
-	var injectMode bool = false
+func addLayoutFlags(fs *flag.FlagSet) *layoutFlags {
+	f := &layoutFlags{
+		fs:          fs,
+		config:      fs.String("config", "", "File of key=value lines setting defaults for the layout flags and -offset"),
+		blockSize:   fs.String("blocksize", "0x1000", "Unit of file offsets and lengths. Hex value required"),
+		headerSize:  fs.String("headersize", "0x10000", "Size of the region preceding SBFS. Hex value required"),
+		checksum:    fs.String("checksum", "sha256", "Header checksum algorithm: sha256 or crc32"),
+		scope:       fs.String("checksum-scope", "header", "Data covered by the checksum: header, or full for the header followed by the files"),
+		checksumPos: fs.String("checksum-pos", "after", "Position of the checksum: after the file table, before it, or auto for whichever verifies"),
+		endian:      fs.String("endian", "little", "Byte order of the file table: little or big"),
+		names:       fs.String("names", "", "File with the names of the file slots, one per line, blank lines for unnamed slots"),
+		magic:       fs.String("magic", sbfs.Magic, "Expected 4-byte magic as stored, also accepted reversed. For experimental formats"),
+		verbose:     fs.Int("v", 0, "Verbosity: 1 logs header scanning, checksums and where each file is read from, 2 also every copy of image data"),
+	}
+	fs.Var(&f.layoutSlots, "layout-slots", "Number of file slots of a layout version, e.g. 0x03=16 (repeatable)")
+	return f
+}
 
-	// flags and sanity checks
-	if isFlagPassed("s") {
-		_, err := fmt.Sscanf(*changeSequence, "0x%x", &newSeq)
-		if err != nil {
-			log.Fatal("Invalid sequence number: ", err)
+// options builds the parse options from the flags.
+func (f *layoutFlags) options() (sbfs.Options, error) {
+	if *f.config != "" {
+		if err := f.loadConfig(*f.config); err != nil {
+			return sbfs.Options{}, err
 		}
-		injectMode = true
 	}
-	// create output dir if needed
-	if isFlagPassed("x") {
-		if _, err := os.Stat(*outputDir); errors.Is(err, os.ErrNotExist) {
-			if err = os.Mkdir(*outputDir, os.ModePerm); err != nil {
-				log.Fatal(err)
-			}
+	verbosity = *f.verbose
+	opts := sbfs.Options{Log: debugf}
+	var err error
+	if opts.BlockSize, err = parseHex(*f.blockSize); err != nil || opts.BlockSize == 0 {
+		return opts, argErrorf("Invalid block size: %s", *f.blockSize)
+	}
+	if opts.HeaderSize, err = parseHex(*f.headerSize); err != nil {
+		return opts, argErrorf("Invalid header size: %v", err)
+	}
+	// the flag always has a value, 0x0 for images starting with the header
+	opts.ExplicitHeaderSize = true
+	for _, v := range f.layoutSlots {
+		var layout uint8
+		var slots int
+		if _, err := fmt.Sscanf(v, "0x%x=%d", &layout, &slots); err != nil || slots <= 0 {
+			return opts, argErrorf("Invalid layout slots: %s", v)
 		}
+		if opts.LayoutSlots == nil {
+			opts.LayoutSlots = map[byte]int{}
+		}
+		opts.LayoutSlots[layout] = slots
+	}
+	switch *f.endian {
+	case "little":
+		opts.ByteOrder = binary.LittleEndian
+	case "big":
+		opts.ByteOrder = binary.BigEndian
+	default:
+		return opts, argErrorf("Invalid byte order: %s", *f.endian)
+	}
+	if opts.Checksum, err = sbfs.ChecksummerByName(*f.checksum); err != nil {
+		return opts, argErrorf("%v", err)
+	}
+	if opts.ChecksumScope, err = sbfs.ScopeByName(*f.scope); err != nil {
+		return opts, argErrorf("%v", err)
 	}
+	if opts.ChecksumPos, err = sbfs.PositionByName(*f.checksumPos); err != nil {
+		return opts, argErrorf("%v", err)
+	}
+	opts.Magic = *f.magic
+	if err := opts.Validate(); err != nil {
+		return opts, argErrorf("%v", err)
+	}
+	if *f.names != "" {
+		fin, err := os.Open(*f.names)
+		if err != nil {
+			return opts, err
+		}
+		defer fin.Close()
+		if sbfs.FileNames, err = sbfs.ReadFileNames(fin); err != nil {
+			return opts, argErrorf("Invalid names file %s: %v", *f.names, err)
+		}
+	}
+	return opts, nil
+}
+
+// configKeys are the flags a -config file may set.
+var configKeys = []string{"blocksize", "headersize", "checksum", "checksum-scope", "checksum-pos", "endian", "layout-slots", "names", "magic", "base", "offset", "assume-offset"}
 
-	file, err := os.Open(*inputFile)
+// loadConfig sets the flags listed in the config file name, a board profile of
+// key=value lines, unless they were given on the command line. Blank lines and
+// lines starting with # are ignored, values may be quoted.
+func (f *layoutFlags) loadConfig(name string) error {
+	data, err := os.ReadFile(name)
 	if err != nil {
-		log.Fatal("Error opening input file: ", err)
+		return err
 	}
-	defer file.Close()
-
-	var header sbfsHeaderWithSha
-	var actualHeaderOffset int64 = 0x00
-	for i := 0; i < SBFS_NUM_HEADER_OFFSETS; i++ {
-		_, err = file.Seek(sbfsHeaderOffsets[i], 0)
-		if err != nil {
-			log.Fatal(err)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimLeft(strings.TrimSpace(key), "-")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if !ok || !slices.Contains(configKeys, key) {
+			return argErrorf("%s:%d: invalid setting: %s", name, i+1, line)
+		}
+		// commands without the flag, like pack for -offset, ignore the setting
+		if f.fs.Lookup(key) == nil || isFlagPassed(f.fs, key) {
+			continue
+		}
+		if err = f.fs.Set(key, value); err != nil {
+			return argErrorf("%s:%d: %v", name, i+1, err)
 		}
+	}
+	return nil
+}
+
+// imageFlags are shared by the commands reading an image.
+type imageFlags struct {
+	*layoutFlags
+	input   *string
+	base    *string
+	offsets stringList
+	assume  *string
+	bank    *string
+}
 
-		err = binary.Read(file, binary.LittleEndian, &header)
+func addImageFlags(fs *flag.FlagSet) *imageFlags {
+	f := &imageFlags{
+		input:       fs.String("f", "sbfs.img", "input file, - reads the image from stdin"),
+		base:        fs.String("base", "", "Offset of the SBFS region within a larger dump, added to every header and file offset. Hex value required"),
+		assume:      fs.String("assume-offset", "", "Read the header at this offset only, without trying any other. Hex value required, takes precedence over -offset"),
+		bank:        fs.String("bank", "", "Scan for A/B banks and use bank a, b or all (all is only accepted by info)"),
+		layoutFlags: addLayoutFlags(fs),
+	}
+	fs.Var(&f.offsets, "offset", "Header offset to try before the default ones, lowest first. Hex value required (repeatable)")
+	return f
+}
+
+// options builds the parse options from the flags.
+func (f *imageFlags) options(fs *flag.FlagSet) (sbfs.Options, error) {
+	opts, err := f.layoutFlags.options()
+	if err != nil {
+		return opts, err
+	}
+	if isFlagPassed(fs, "base") {
+		if opts.Base, err = parseHex(*f.base); err != nil || opts.Base < 0 {
+			return opts, argErrorf("Invalid base: %s", *f.base)
+		}
+	}
+	// user offsets count from the base like the default ones
+	for _, s := range f.offsets {
+		userOffset, err := parseHex(s)
 		if err != nil {
-			log.Fatal(err)
+			return opts, argErrorf("Invalid header offset: %v", err)
 		}
-		// check if it's axctual header
-		if string(header.Header.Magic[:]) == sbfsMagic {
-			actualHeaderOffset = sbfsHeaderOffsets[i]
-			break
+		opts.HeaderOffsets = append(opts.HeaderOffsets, opts.Base+userOffset)
+	}
+	if isFlagPassed(fs, "assume-offset") {
+		assumed, err := parseHex(*f.assume)
+		if err != nil {
+			return opts, argErrorf("Invalid header offset: %v", err)
 		}
+		opts.HeaderOffsets = []int64{opts.Base + assumed}
+		opts.OnlyHeaderOffsets = true
 	}
-	if actualHeaderOffset == 0x00 {
-		log.Fatal("Invalid file. Could not find valid header\n")
+	switch *f.bank {
+	case "", "a", "b", "all":
+	default:
+		return opts, argErrorf("Invalid bank: %s", *f.bank)
 	}
+	return opts, nil
+}
 
-	// in injectMode we do not output info
-	if !injectMode {
-		fmt.Printf("\n=== SBFS Header ===\n")
-		fmt.Printf("%16s: %s (at offset: 0x%06X)\n", "Magic", reverseString(string(header.Header.Magic[:])), actualHeaderOffset)
-		fmt.Printf("%16s: 0x%02X\n", "Format Version", header.Header.FormatVersion)
-		fmt.Printf("%16s: 0x%02X\n", "Sequence Number", header.Header.SequenceNumber)
-		fmt.Printf("%16s: 0x%02X\n", "Layout Version", header.Header.LayoutVersion)
-		fmt.Printf("%16s: 0x%02X\n", "SHA", header.Checksum)
+// open opens and parses the input image. With -bank the selected bank is returned.
+func (f *imageFlags) open(opts sbfs.Options) (input, *sbfs.Image, error) {
+	if *f.bank == "all" {
+		return nil, nil, argErrorf("-bank all is only supported by info")
+	}
+	file, banks, err := f.openBanks(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, banks[0], nil
+}
 
-		// copy initial chunk of data
-		if isFlagPassed("x") {
-			var fout *os.File
-			fullFilePath := filepath.Join(*outputDir, "data.hdr")
-			fout, err = os.Create(fullFilePath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			_, err = file.Seek(0x0, 0)
-			if err != nil {
-				log.Fatal(err)
+// openBanks is like open but returns every bank for -bank all.
+func (f *imageFlags) openBanks(opts sbfs.Options) (input, []*sbfs.Image, error) {
+	file, img, err := openImage(*f.input, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(opts.HeaderOffsets) > 0 {
+		if slices.Contains(opts.HeaderOffsets, img.HeaderOffset) {
+			fmt.Fprintf(os.Stderr, "Header found at user offset 0x%06X\n", img.HeaderOffset)
+		} else {
+			tried := make([]string, len(opts.HeaderOffsets))
+			for i, off := range opts.HeaderOffsets {
+				tried[i] = fmt.Sprintf("0x%06X", off)
 			}
-			_, err = io.CopyN(fout, file, 0x10000)
-			fout.Close()
+			fmt.Fprintf(os.Stderr, "No header at user offsets %s, matched 0x%06X instead\n", strings.Join(tried, ", "), img.HeaderOffset)
 		}
+	}
+	if *f.bank == "" {
+		return file, []*sbfs.Image{img}, nil
+	}
 
-		fmt.Printf("\n=== SBFS Files ===\n")
-		for i := 0; i < SBFS_NUM_FILES; i++ {
-			filePtr := header.Header.Files[i]
-			if filePtr.Length == 0x00 {
-				continue
-			}
-			fmt.Printf("%16s %10s:0x%06X %10s:0x%06X\n", sbfsFileNames[i], "Offset", filePtr.Offset*0x1000, "Length", filePtr.Length*0x1000)
-			if isFlagPassed("x") {
-				var fout *os.File
-				fullFilePath := filepath.Join(*outputDir, sbfsFileNames[i])
-				fout, err = os.Create(fullFilePath)
-				if err != nil {
-					log.Fatal(err)
-				}
-				_, err = file.Seek(int64(filePtr.Offset)*0x1000, 0)
-				if err != nil {
-					log.Fatal(err)
-				}
-				_, err = io.CopyN(fout, file, int64(filePtr.Length)*0x1000)
-				fout.Close()
+	banks, err := sbfs.ParseBanks(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %w", *f.input, err)
+	}
+	for i, b := range banks {
+		fmt.Fprintf(os.Stderr, "Bank %s: header at 0x%06X, sequence number 0x%02X\n", bankName(i), b.HeaderOffset, b.Header.Header.SequenceNumber)
+	}
+	switch *f.bank {
+	case "b":
+		if len(banks) < 2 {
+			file.Close()
+			return nil, nil, fmt.Errorf("%s: no second bank found: %w", *f.input, sbfs.ErrNoHeader)
+		}
+		return file, banks[1:2], nil
+	case "all":
+		return file, banks, nil
+	}
+	return file, banks[:1], nil
+}
+
+// bankName returns the letter naming bank i.
+func bankName(i int) string {
+	return string(rune('A' + i))
+}
+
+// openImage opens and parses the image name.
+func openImage(name string, opts sbfs.Options) (input, *sbfs.Image, error) {
+	file, err := openInput(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening input file: %w", err)
+	}
+	img, err := sbfs.ParseWithOptions(file, opts)
+	if err != nil {
+		var rejected *sbfs.NoHeaderError
+		if errors.As(err, &rejected) {
+			printCandidates(os.Stderr, rejected.Candidates, opts.Magic)
+		}
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return file, img, nil
+}
+
+// printCandidates shows the magic found at each rejected header offset, magic is the
+// one expected.
+func printCandidates(w io.Writer, candidates []sbfs.Candidate, magic string) {
+	if magic == "" {
+		magic = sbfs.Magic
+	}
+	fmt.Fprintf(w, "Rejected header offsets (expecting %q or %q):\n", magic, reverseString(magic))
+	for _, c := range candidates {
+		if len(c.Magic) == 0 {
+			fmt.Fprintf(w, "%16s: past the end of the image\n", fmt.Sprintf("0x%06X", c.Offset))
+			continue
+		}
+		ascii := []byte(string(c.Magic))
+		for i, b := range ascii {
+			if b < 0x20 || b > 0x7E {
+				ascii[i] = '.'
 			}
 		}
-		fmt.Printf("\n")
-		return
+		fmt.Fprintf(w, "%16s: % X  %s\n", fmt.Sprintf("0x%06X", c.Offset), c.Magic, ascii)
 	}
-	// inject mode
-	fmt.Printf("\n=== Updating SBFS ===\n")
+}
+
+// reverseString reverses str rune by rune, keeping multibyte characters intact.
+func reverseString(str string) string {
+	r := []rune(str)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
 
-	// modify header
-	if isFlagPassed("s") {
-		header.Header.SequenceNumber = newSeq
-		buf := new(bytes.Buffer)
-		err = binary.Write(buf, binary.LittleEndian, header.Header)
+// displayMagic returns the magic in reading order regardless of how it is stored.
+func displayMagic(img *sbfs.Image) string {
+	magic := string(img.Header.Header.Magic[:])
+	if img.MagicReversed {
+		return reverseString(magic)
+	}
+	return magic
+}
+
+type input interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// memInput is an image held in memory.
+type memInput struct {
+	*bytes.Reader
+}
+
+func (memInput) Close() error { return nil }
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openInput opens the image to work on. Parsing needs random access, so with "-"
+// the whole of stdin is read into memory before parsing, which for a full NOR dump
+// means holding the entire image (typically 16MB) in RAM. Gzip compressed images,
+// recognized by their magic, are decompressed into memory the same way; other files
+// are read directly whatever their extension, inject -o out.img.gz writes a plain image.
+func openInput(name string) (input, error) {
+	if name == "-" {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		header.Checksum = sha256.Sum256(buf.Bytes())
-		fmt.Printf("%20s: 0x%02X\n", "New Sequence number", newSeq)
-		fmt.Printf("%20s: 0x%02X\n", "New SHA256 checksum", header.Checksum)
+		if bytes.HasPrefix(data, gzipMagic) {
+			return gunzip(bytes.NewReader(data))
+		}
+		return memInput{bytes.NewReader(data)}, nil
 	}
-
-	// write everything out
-	var fout *os.File
-	outFileName := *inputFile + ".out"
-	fout, err = os.Create(outFileName)
+	f, err := os.Open(name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	// copy up to header
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		log.Fatal(err)
+	magic := make([]byte, len(gzipMagic))
+	n, _ := f.ReadAt(magic, 0)
+	if !bytes.Equal(magic[:n], gzipMagic) {
+		if strings.HasSuffix(name, ".gz") {
+			debugf(1, "%s has no gzip magic, reading it uncompressed", name)
+		}
+		return f, nil
 	}
-	_, err = io.CopyN(fout, file, actualHeaderOffset)
+	defer f.Close()
+	debugf(1, "decompressing %s", name)
+	return gunzip(f)
+}
+
+// gunzip decompresses r into memory.
+func gunzip(r io.Reader) (input, error) {
+	zr, err := gzip.NewReader(r)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	buf := new(bytes.Buffer)
-	err = binary.Write(buf, binary.LittleEndian, header)
+	data, err := io.ReadAll(zr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	_, err = fout.Write(buf.Bytes())
-	if err != nil {
-		log.Fatal(err)
+	return memInput{bytes.NewReader(data)}, nil
+}
+
+// sameFile reports whether f refers to the file described by fi.
+func sameFile(f *os.File, fi os.FileInfo) bool {
+	in, err := f.Stat()
+	return err == nil && os.SameFile(fi, in)
+}
+
+// verbosity is the level set by -v, 0 logs nothing extra.
+var verbosity int
+
+// debugf logs at the given verbosity level.
+func debugf(level int, format string, v ...any) {
+	if level <= verbosity {
+		log.Printf(format, v...)
 	}
-	// copy the rest of the sbfs
-	_, err = file.Seek(actualHeaderOffset+int64(len(buf.Bytes())), 0)
-	if err != nil {
-		log.Fatal(err)
+}
+
+// run dispatches args to the command they name or, without one, to the command
+// selected by the legacy mode flags.
+func run(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			usage()
+			return nil
+		}
+		for _, c := range commands {
+			if c.name == args[0] {
+				return c.run(args[1:])
+			}
+		}
+		usage()
+		return exitStatus(exitFailure)
+	}
+	if slices.Contains(args, "-h") || slices.Contains(args, "-help") || slices.Contains(args, "--help") {
+		usage()
+		return nil
 	}
 
-	_, err = io.Copy(fout, file)
+	name, err := legacyCommand(args)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args)
+		}
 	}
-	fout.Close()
+	return nil
+}
 
-	fmt.Printf("\nSBFS written to: %s\n", outFileName)
-	fmt.Printf("\n")
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		var status exitStatus
+		if !errors.As(err, &status) {
+			log.Print(err)
+		}
+		os.Exit(exitCode(err))
+	}
 }
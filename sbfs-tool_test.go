@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// packTestImage packs files, keyed by slot, into an image whose header follows pre.
+// The header has format 0x01, layout 0x02 and sequence number 0x07; edit, if not
+// nil, changes it further before packing.
+func packTestImage(t *testing.T, pre []byte, files map[int][]byte, edit func(h *sbfs.Header)) []byte {
+	t.Helper()
+	tmpl := sbfs.Header{FormatVersion: 0x01, SequenceNumber: 0x07, LayoutVersion: 0x02}
+	tmpl.Files = make([]sbfs.File, sbfs.NumFiles)
+	if edit != nil {
+		edit(&tmpl)
+	}
+	slots := make([][]byte, len(tmpl.Files))
+	for i, data := range files {
+		slots[i] = data
+	}
+	var buf bytes.Buffer
+	if _, err := sbfs.Pack(&buf, pre, tmpl, slots, sbfs.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReverseString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"S", "S"},
+		{"SFBS", "SBFS"},
+		{"abc", "cba"},
+		// multibyte runes must survive, a byte reverse would break them
+		{"aé€", "€éa"},
+	}
+	for _, tt := range tests {
+		if got := reverseString(tt.in); got != tt.want {
+			t.Errorf("reverseString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0x1000", 0x1000, true},
+		{"0x0", 0, true},
+		{"0xFFff", 0xFFFF, true},
+		{"0x1000garbage", 0, false},
+		{"0x1000 ", 0, false},
+		{"1000", 0, false},
+		{"0x", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseHex(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseHex(%q) = 0x%X, %v, want 0x%X, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestNamesFile checks that the names of a -names file are accepted wherever a
+// file is named on the command line.
+func TestNamesFile(t *testing.T) {
+	defer func(names []string) { sbfs.FileNames = names }(slices.Clone(sbfs.FileNames))
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	in := write("in.img", packTestImage(t, make([]byte, sbfs.NorHeaderSize), map[int][]byte{
+		0: bytes.Repeat([]byte{0xA0}, 0x1000),
+		3: bytes.Repeat([]byte{0xA3}, 0x1000),
+	}, nil))
+	names := write("names.txt", []byte("kernel.bin\n\n\nlog.bin\n"))
+	replacement := write("new.bin", []byte("new kernel"))
+
+	out := filepath.Join(dir, "out")
+	if err := runExtract([]string{"-f", in, "-names", names, "-only", "kernel.bin", "-x", out}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "kernel.bin")); err != nil {
+		t.Errorf("-only kernel.bin: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "log.bin")); err == nil {
+		t.Error("-only kernel.bin also extracted log.bin")
+	}
+
+	// -index selects by slot, whatever the slot is called
+	byIndex := filepath.Join(dir, "by-index")
+	if err := runExtract([]string{"-f", in, "-names", names, "-index", "3", "-x", byIndex}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(byIndex, "log.bin")); err != nil {
+		t.Errorf("-index 3: %v", err)
+	}
+
+	args := []string{"-f", in, "-names", names, "-replace", "kernel.bin=" + replacement, "-delete", "log.bin", "-o", filepath.Join(dir, "out.img")}
+	if err := runInject(args); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,170 @@
+package sbfs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// ParseBanks parses every SBFS bank of an image holding several copies, as in A/B
+// failover layouts. The first bank is found as by ParseWithOptions. Further banks
+// are found by scanning the block boundaries past its header for the magic,
+// skipping those inside the files of the banks found so far. Images of unknown
+// size only yield the first bank.
+func ParseBanks(r io.ReaderAt, opts Options) ([]*Image, error) {
+	first, err := ParseWithOptions(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	banks := []*Image{first}
+	if first.Size < 0 {
+		return banks, nil
+	}
+
+	opts = first.opts
+	bs := opts.BlockSize
+	// block boundaries count from the base
+	start := opts.Base + (first.HeaderOffset-opts.Base+int64(first.Header.Size())+bs-1)/bs*bs
+	var h Header
+	for off := start; off+int64(first.Header.Size()) <= first.Size; off += bs {
+		if inBankFiles(banks, off) {
+			continue
+		}
+		if _, err := r.ReadAt(h.Magic[:], off); err != nil {
+			break
+		}
+		if !h.HasValidMagic(opts.Magic) {
+			continue
+		}
+		bankOpts := opts
+		bankOpts.HeaderOffsets = []int64{off}
+		img, err := ParseWithOptions(r, bankOpts)
+		if err != nil || img.HeaderOffset != off {
+			continue
+		}
+		opts.logf(1, "bank %d header at 0x%06X", len(banks), off)
+		banks = append(banks, img)
+	}
+	return banks, nil
+}
+
+// inBankFiles reports whether off lies within a file of one of banks.
+func inBankFiles(banks []*Image, off int64) bool {
+	for _, b := range banks {
+		for _, f := range b.Files {
+			if f.Length != 0x00 && off >= f.Offset && off < f.Offset+f.Length {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewerSequence reports whether sequence number a is newer than b. Sequence numbers
+// wrap around, so a is newer if it is less than half the range ahead of b.
+func NewerSequence(a, b byte) bool {
+	return int8(a-b) > 0
+}
+
+// ActiveBank returns the index of the bank a device boots from: the one with the
+// newest sequence number among the banks whose checksum verifies. If no bank
+// verifies the error of the first one is returned.
+func ActiveBank(banks []*Image) (int, error) {
+	active := -1
+	var first error
+	for i, b := range banks {
+		if err := b.Verify(); err != nil {
+			b.opts.logf(1, "bank %d: %v", i, err)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		if active < 0 || NewerSequence(b.Header.Header.SequenceNumber, banks[active].Header.Header.SequenceNumber) {
+			active = i
+		}
+	}
+	if active < 0 {
+		return -1, first
+	}
+	return active, nil
+}
+
+// MergeBanks returns an image whose WriteTo writes the headers and replaced files of
+// every bank of banks, which must all come from the same image. Its header and
+// file table are those of the first bank.
+func MergeBanks(banks []*Image) *Image {
+	merged := *banks[0]
+	merged.patches = append([]patch{}, merged.patches...)
+	for _, b := range banks[1:] {
+		merged.patches = append(merged.patches, patch{offset: b.HeaderOffset, data: b.HeaderBytes()})
+		merged.patches = append(merged.patches, b.patches...)
+	}
+	return &merged
+}
+
+// CopyBank turns bank dst into a copy of bank src, both from the same image: dst
+// gets the header of src with the file offsets moved by the distance between the two
+// headers, and WriteTo writes the contents of the files of src at the moved offsets.
+// The sequence number of the copy follows that of src, so the device boots from it,
+// and its checksum is updated. The copy must lie within the image and must not
+// overlap the header or files of src.
+func CopyBank(src, dst *Image) error {
+	bs := dst.opts.BlockSize
+	delta := dst.HeaderOffset - src.HeaderOffset
+	if delta%bs != 0 {
+		return fmt.Errorf("headers at 0x%06X and 0x%06X are not a whole number of blocks apart", src.HeaderOffset, dst.HeaderOffset)
+	}
+
+	// everything of src must stay intact while it is copied
+	var used []Range
+	used = append(used, Range{src.HeaderOffset, src.HeaderOffset + int64(src.Header.Size())})
+	for _, f := range src.Files {
+		if f.Length != 0x00 {
+			used = append(used, Range{f.Offset, f.Offset + f.Length})
+		}
+	}
+	placed := []Range{{dst.HeaderOffset, dst.HeaderOffset + int64(src.Header.Size())}}
+	h := src.Header
+	h.Header.Files = slices.Clone(src.Header.Header.Files)
+	var patches []patch
+	for i, f := range src.Files {
+		if f.Length == 0x00 {
+			continue
+		}
+		data, err := src.contents(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", FileName(i), err)
+		}
+		block := int64(h.Header.Files[i].Offset) + delta/bs
+		if block < 0 || block > math.MaxUint32 {
+			return fmt.Errorf("%s cannot be moved to 0x%06X", FileName(i), f.Offset+delta)
+		}
+		h.Header.Files[i].Offset = uint32(block)
+		patches = append(patches, patch{offset: f.Offset + delta, data: data})
+		placed = append(placed, Range{f.Offset + delta, f.Offset + delta + f.Length})
+	}
+	for _, p := range placed {
+		if dst.Size >= 0 && p.End > dst.Size {
+			return fmt.Errorf("%w: copy 0x%06X-0x%06X, image ends at 0x%06X", ErrOutOfBounds, p.Start, p.End, dst.Size)
+		}
+		for _, u := range used {
+			if p.Start < u.End && u.Start < p.End {
+				return fmt.Errorf("copy 0x%06X-0x%06X overlaps the source bank at 0x%06X-0x%06X", p.Start, p.End, u.Start, u.End)
+			}
+		}
+	}
+
+	h.Header.SequenceNumber = src.Header.Header.SequenceNumber + 1
+	dst.Header = h
+	dst.Files = make([]FileInfo, len(src.Files))
+	for i, f := range src.Files {
+		dst.Files[i] = FileInfo{Index: i, Length: f.Length}
+		if f.Length != 0x00 {
+			dst.Files[i].Offset = f.Offset + delta
+		}
+	}
+	dst.patches = patches
+	return dst.UpdateChecksum()
+}
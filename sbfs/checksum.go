@@ -0,0 +1,101 @@
+package sbfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Checksummer computes the contents of the 32-byte header checksum field.
+type Checksummer interface {
+	// Name identifies the algorithm.
+	Name() string
+	// Sum returns the checksum field for the serialized header.
+	Sum(header []byte) [32]byte
+}
+
+var (
+	// SHA256 fills the checksum field with the SHA256 of the header. This is the default.
+	SHA256 Checksummer = sha256Checksummer{}
+	// CRC32 stores the little-endian IEEE CRC32 of the header in the first 4 bytes
+	// of the checksum field, the rest is zero.
+	CRC32 Checksummer = crc32Checksummer{}
+
+	checksummers = []Checksummer{SHA256, CRC32}
+)
+
+type sha256Checksummer struct{}
+
+func (sha256Checksummer) Name() string { return "sha256" }
+
+func (sha256Checksummer) Sum(header []byte) [32]byte {
+	return sha256.Sum256(header)
+}
+
+type crc32Checksummer struct{}
+
+func (crc32Checksummer) Name() string { return "crc32" }
+
+func (crc32Checksummer) Sum(header []byte) (sum [32]byte) {
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(header))
+	return
+}
+
+// ChecksumScope selects the data covered by the header checksum.
+type ChecksumScope int
+
+const (
+	// ScopeHeader covers the serialized header only. This is the default.
+	ScopeHeader ChecksumScope = iota
+	// ScopeFull covers the header followed by the contents of each file in table order.
+	ScopeFull
+)
+
+// ScopeByName returns the checksum scope called name, "header" or "full".
+func ScopeByName(name string) (ChecksumScope, error) {
+	switch name {
+	case "header":
+		return ScopeHeader, nil
+	case "full":
+		return ScopeFull, nil
+	}
+	return 0, fmt.Errorf("unknown checksum scope %q", name)
+}
+
+// ChecksumPosition selects where the checksum is stored in the header.
+type ChecksumPosition int
+
+const (
+	// ChecksumAfter stores the checksum after the file table. This is the default.
+	ChecksumAfter ChecksumPosition = iota
+	// ChecksumBefore stores the checksum between the fixed fields and the file table,
+	// as some layout variants do.
+	ChecksumBefore
+	// ChecksumAuto tries ChecksumAfter first and uses ChecksumBefore instead if only
+	// that verifies.
+	ChecksumAuto
+)
+
+// PositionByName returns the checksum position called name, "after", "before" or "auto".
+func PositionByName(name string) (ChecksumPosition, error) {
+	switch name {
+	case "after":
+		return ChecksumAfter, nil
+	case "before":
+		return ChecksumBefore, nil
+	case "auto":
+		return ChecksumAuto, nil
+	}
+	return 0, fmt.Errorf("unknown checksum position %q", name)
+}
+
+// ChecksummerByName returns the checksum algorithm called name.
+func ChecksummerByName(name string) (Checksummer, error) {
+	for _, c := range checksummers {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %q", name)
+}
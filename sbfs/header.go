@@ -0,0 +1,207 @@
+package sbfs
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// On-disk sizes of the header parts. Offsets in the image depend on them, a test
+// checks that the structs still serialize to these sizes.
+const (
+	// HeaderPrefixSize is the size of the fields preceding the file table.
+	HeaderPrefixSize = 0x20
+	// FileEntrySize is the size of one file table slot.
+	FileEntrySize = 0x10
+	// ChecksumSize is the size of the checksum following the file table.
+	ChecksumSize = 0x20
+	// DefaultHeaderSize is the size of a header with NumFiles slots and its checksum.
+	DefaultHeaderSize = HeaderPrefixSize + NumFiles*FileEntrySize + ChecksumSize
+)
+
+// LayoutSlots maps layout versions to their number of file table slots.
+// Layouts not listed use NumFiles.
+var LayoutSlots = map[byte]int{}
+
+// Version identifies a header format by its format and layout versions.
+type Version struct {
+	Format, Layout byte
+}
+
+// KnownVersions are the versions whose file table is known to be read correctly.
+// The offsets and lengths of other versions may mean something else.
+var KnownVersions = []Version{
+	{Format: 0x01, Layout: 0x02},
+}
+
+// Unknown1Notes labels the values of Header.Unknown1 whose meaning has been worked
+// out, per version. Nothing is known about it yet; add an entry once a value has
+// been correlated with device behavior, e.g.
+//
+//	{Format: 0x01, Layout: 0x02}: {0x03: "description of what 0x03 does"},
+var Unknown1Notes = map[Version]map[byte]string{}
+
+// Unknown1Note returns the note on the Unknown1 value of h from Unknown1Notes, or ""
+// if there is none.
+func (h *Header) Unknown1Note() string {
+	return Unknown1Notes[Version{Format: h.FormatVersion, Layout: h.LayoutVersion}][h.Unknown1]
+}
+
+type File struct {
+	Offset  uint32
+	Length  uint32
+	Unknown [8]byte
+}
+
+type Header struct {
+	Magic          [4]byte
+	FormatVersion  byte
+	SequenceNumber byte
+	LayoutVersion  byte
+	Unknown1       byte
+	Unknown2       [24]byte
+	// Files has one entry per slot, its length depends on LayoutVersion
+	Files []File
+}
+
+// headerPrefix is the fixed-size part of Header preceding the file table. Encode and
+// ReadHeader work on the bytes directly, a test checks them against binary.Write of
+// these structs.
+type headerPrefix struct {
+	Magic          [4]byte
+	FormatVersion  byte
+	SequenceNumber byte
+	LayoutVersion  byte
+	Unknown1       byte
+	Unknown2       [24]byte
+}
+
+type HeaderWithSha struct {
+	Header   Header
+	Checksum [32]byte
+}
+
+// slots returns the number of file table slots used by layout.
+func (o Options) slots(layout byte) int {
+	if n, ok := o.LayoutSlots[layout]; ok {
+		return n
+	}
+	if n, ok := LayoutSlots[layout]; ok {
+		return n
+	}
+	return NumFiles
+}
+
+// ReadHeader reads a header and its checksum from r. The size of the file table is
+// chosen by the layout version and the checksum position by opts, ChecksumAuto
+// reads as ChecksumAfter.
+func ReadHeader(r io.Reader, opts Options) (*HeaderWithSha, error) {
+	order := opts.withDefaults().ByteOrder
+	prefix := make([]byte, HeaderPrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	h := &HeaderWithSha{Header: Header{
+		FormatVersion:  prefix[4],
+		SequenceNumber: prefix[5],
+		LayoutVersion:  prefix[6],
+		Unknown1:       prefix[7],
+	}}
+	copy(h.Header.Magic[:], prefix[0:4])
+	copy(h.Header.Unknown2[:], prefix[8:])
+
+	// the file table and checksum in one read, decoded by hand as binary.Read
+	// allocates for every call
+	h.Header.Files = make([]File, opts.slots(h.Header.LayoutVersion))
+	rest := make([]byte, len(h.Header.Files)*FileEntrySize+ChecksumSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	table, sum := rest[:len(rest)-ChecksumSize], rest[len(rest)-ChecksumSize:]
+	if opts.ChecksumPos == ChecksumBefore {
+		sum, table = rest[:ChecksumSize], rest[ChecksumSize:]
+	}
+	for i := range h.Header.Files {
+		e := table[i*FileEntrySize:]
+		h.Header.Files[i].Offset = order.Uint32(e[0:4])
+		h.Header.Files[i].Length = order.Uint32(e[4:8])
+		copy(h.Header.Files[i].Unknown[:], e[8:16])
+	}
+	copy(h.Checksum[:], sum)
+	return h, nil
+}
+
+// Size returns the size of the serialized header.
+func (h *Header) Size() int {
+	return HeaderPrefixSize + len(h.Files)*FileEntrySize
+}
+
+// Bytes returns the serialized header in little-endian byte order.
+func (h *Header) Bytes() []byte {
+	return h.Encode(binary.LittleEndian)
+}
+
+// Encode returns the header serialized in the given byte order. The fields are
+// written by hand into a single buffer, which leaves room for the checksum.
+func (h *Header) Encode(order binary.ByteOrder) []byte {
+	b := make([]byte, h.Size(), h.Size()+ChecksumSize)
+	copy(b[0:4], h.Magic[:])
+	b[4], b[5], b[6], b[7] = h.FormatVersion, h.SequenceNumber, h.LayoutVersion, h.Unknown1
+	copy(b[8:HeaderPrefixSize], h.Unknown2[:])
+	for i, f := range h.Files {
+		e := b[HeaderPrefixSize+i*FileEntrySize:]
+		order.PutUint32(e[0:4], f.Offset)
+		order.PutUint32(e[4:8], f.Length)
+		copy(e[8:16], f.Unknown[:])
+	}
+	return b
+}
+
+// HasValidMagic reports whether the header starts with magic in either byte order,
+// Magic if magic is empty.
+func (h *Header) HasValidMagic(magic string) bool {
+	if magic == "" {
+		magic = Magic
+	}
+	return string(h.Magic[:]) == magic || string(h.Magic[:]) == reverseMagic(magic)
+}
+
+// Checksum computes the SHA256 over the serialized header.
+func (h *Header) Checksum() [32]byte {
+	return SHA256.Sum(h.Bytes())
+}
+
+// Size returns the size of the serialized header including the checksum.
+func (h *HeaderWithSha) Size() int {
+	return h.Header.Size() + len(h.Checksum)
+}
+
+// Bytes returns the serialized header followed by the checksum.
+func (h *HeaderWithSha) Bytes() []byte {
+	return h.Encode(binary.LittleEndian)
+}
+
+// Encode returns the header serialized in the given byte order followed by the checksum.
+func (h *HeaderWithSha) Encode(order binary.ByteOrder) []byte {
+	return append(h.Header.Encode(order), h.Checksum[:]...)
+}
+
+// EncodeWith returns the header serialized as stored with opts: in their byte order
+// and with the checksum at their position.
+func (h *HeaderWithSha) EncodeWith(opts Options) []byte {
+	opts = opts.withDefaults()
+	b := h.Encode(opts.ByteOrder)
+	if opts.ChecksumPos == ChecksumBefore {
+		// move the file table behind the checksum
+		copy(b[HeaderPrefixSize+ChecksumSize:], b[HeaderPrefixSize:h.Header.Size()])
+		copy(b[HeaderPrefixSize:], h.Checksum[:])
+	}
+	return b
+}
+
+// checksumOffset returns the offset of the checksum in the header h stored with opts.
+func (h *HeaderWithSha) checksumOffset(opts Options) int {
+	if opts.ChecksumPos == ChecksumBefore {
+		return HeaderPrefixSize
+	}
+	return h.Header.Size()
+}
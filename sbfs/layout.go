@@ -0,0 +1,77 @@
+package sbfs
+
+import "sort"
+
+// Range is the half-open byte range [Start, End) of an image.
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// Overlap is a range claimed by both file A and file B.
+type Overlap struct {
+	A, B int
+	Range
+}
+
+// CheckLayout sorts the non-empty files by offset and returns the ranges claimed by
+// more than one file as well as the unclaimed ranges between files.
+func (img *Image) CheckLayout() (overlaps []Overlap, gaps []Range) {
+	var files []FileInfo
+	for _, f := range img.Files {
+		if f.Length != 0x00 {
+			files = append(files, f)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Offset < files[j].Offset })
+
+	for i, f := range files {
+		end := f.Offset + f.Length
+		for _, g := range files[i+1:] {
+			if g.Offset >= end {
+				break
+			}
+			overlaps = append(overlaps, Overlap{A: f.Index, B: g.Index, Range: Range{g.Offset, min(end, g.Offset+g.Length)}})
+		}
+	}
+
+	var covered int64
+	for i, f := range files {
+		if i > 0 && f.Offset > covered {
+			gaps = append(gaps, Range{covered, f.Offset})
+		}
+		covered = max(covered, f.Offset+f.Length)
+	}
+	return overlaps, gaps
+}
+
+// Unclaimed returns the ranges between the end of the header and the end of the
+// image that belong to no file. Files past the end of the image are ignored, for
+// images of unknown size the area ends with the last file.
+func (img *Image) Unclaimed() []Range {
+	start := img.HeaderOffset + int64(img.Header.Size())
+	end := img.Size
+	var files []FileInfo
+	for _, f := range img.Files {
+		if f.Length != 0x00 && img.CheckBounds(f) == nil {
+			files = append(files, f)
+			if img.Size < 0 {
+				end = max(end, f.Offset+f.Length)
+			}
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Offset < files[j].Offset })
+
+	var gaps []Range
+	covered := start
+	for _, f := range files {
+		if f.Offset > covered {
+			gaps = append(gaps, Range{covered, f.Offset})
+		}
+		covered = max(covered, f.Offset+f.Length)
+	}
+	if end > covered {
+		gaps = append(gaps, Range{covered, end})
+	}
+	return gaps
+}
@@ -0,0 +1,98 @@
+package sbfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Pack builds a complete image from its parts and writes it to w.
+//
+// The layout is deterministic:
+//   - pre, the region preceding SBFS (data.hdr), is written at offset 0
+//   - the header follows at len(pre)
+//   - files are placed in file table order, files[i] being the contents of slot i
+//     and nil marking an empty slot; the file table has len(files) slots. A file
+//     keeps the offset recorded for it in tmpl if that does not overlap what was
+//     placed before it, otherwise it goes to the next free block. Each file is
+//     padded with 0xFF to a whole number of blocks.
+//   - gaps between files are filled with 0xFF and the image ends after the last file
+//
+// All other header fields, including the magic and the unknown fields, are taken
+// from tmpl, so packing the unmodified output of an extraction reproduces the
+// original image as long as it did not hold data outside of the files.
+// The header written is returned with its checksum filled in.
+func Pack(w io.Writer, pre []byte, tmpl Header, files [][]byte, opts Options) (*HeaderWithSha, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	bs := opts.BlockSize
+
+	hdr := &HeaderWithSha{Header: tmpl}
+	if hdr.Header.Magic == [4]byte{} {
+		copy(hdr.Header.Magic[:], opts.Magic)
+	}
+	hdr.Header.Files = make([]File, len(files))
+	copy(hdr.Header.Files, tmpl.Files)
+	headerOffset := int64(len(pre))
+	pos := headerOffset + int64(hdr.Size())
+	// first free block after the header
+	pos = (pos + bs - 1) / bs * bs
+
+	for i, data := range files {
+		entry := &hdr.Header.Files[i]
+		if data == nil {
+			entry.Offset, entry.Length = 0, 0
+			continue
+		}
+		if want := int64(entry.Offset) * bs; entry.Length != 0x00 && want >= pos {
+			pos = want
+		}
+		blocks := (int64(len(data)) + bs - 1) / bs
+		if pos/bs > 0xFFFFFFFF || blocks > 0xFFFFFFFF {
+			return nil, fmt.Errorf("%s does not fit in the file table", FileName(i))
+		}
+		entry.Offset = uint32(pos / bs)
+		entry.Length = uint32(blocks)
+		opts.logf(2, "placing %s at 0x%06X-0x%06X", FileName(i), pos, pos+blocks*bs)
+		pos += blocks * bs
+	}
+	sumData := hdr.Header.Encode(opts.ByteOrder)
+	if opts.ChecksumScope == ScopeFull {
+		for i, data := range files {
+			if data == nil {
+				continue
+			}
+			padded := bytes.Repeat([]byte{0xFF}, int(int64(hdr.Header.Files[i].Length)*bs))
+			copy(padded, data)
+			sumData = append(sumData, padded...)
+		}
+	}
+	hdr.Checksum = opts.Checksum.Sum(sumData)
+
+	if _, err := w.Write(pre); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(hdr.EncodeWith(opts)); err != nil {
+		return nil, err
+	}
+	written := headerOffset + int64(hdr.Size())
+	for i, data := range files {
+		if data == nil {
+			continue
+		}
+		entry := hdr.Header.Files[i]
+		offset := int64(entry.Offset) * bs
+		if _, err := w.Write(bytes.Repeat([]byte{0xFF}, int(offset-written))); err != nil {
+			return nil, err
+		}
+		padded := bytes.Repeat([]byte{0xFF}, int(int64(entry.Length)*bs))
+		copy(padded, data)
+		if _, err := w.Write(padded); err != nil {
+			return nil, err
+		}
+		written = offset + int64(len(padded))
+	}
+	return hdr, nil
+}
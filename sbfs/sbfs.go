@@ -0,0 +1,701 @@
+// Package sbfs implements parsing of SBFS images found in NOR dumps.
+package sbfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	NumFiles = 12
+	// offsets and lengths in the file table are stored in units of BlockSize
+	BlockSize = 0x1000
+	// initial 0x10000 bytes of the dump contains some data that is not part of SBFS
+	NorHeaderSize = 0x010000
+)
+
+var (
+	// SBFS file names, empty names and slots past the end of the list are named by FileName
+	FileNames = []string{
+		"smcfw.bin",
+		"psp1sp.bin",
+		"speaker.bin",
+		"smcerr.log",
+		"smc_d.cfg",
+		"certkeys.smc",
+	}
+
+	// potential header offsets, relative to a pre-header region of NorHeaderSize bytes
+	HeaderOffsets = []int64{
+		0x10000,
+		0x11000,
+	}
+
+	// magic string as stored in most dumps, some store it in order as "SBFS". Near-identical
+	// formats with another 4-byte magic can be read by setting Options.Magic.
+	Magic = "SFBS"
+)
+
+var (
+	// ErrNoHeader is returned by Parse when none of the HeaderOffsets hold a valid header,
+	// including when the image ends before them.
+	ErrNoHeader = errors.New("invalid file: could not find valid header")
+	// ErrBadChecksum is returned by Verify when the stored checksum does not match the header.
+	ErrBadChecksum = errors.New("checksum mismatch")
+	// ErrNoChecksum is returned by Verify when the stored checksum is all zeros.
+	ErrNoChecksum = errors.New("checksum not initialized")
+	// ErrOutOfBounds is returned when a file table entry points past the end of the image.
+	ErrOutOfBounds = errors.New("file exceeds image size")
+	// ErrUnknownFile is returned by Open for names that do not map to a slot of the file table.
+	ErrUnknownFile = errors.New("unknown file name")
+	// ErrEmptySlot is returned when an operation targets an unused file table entry.
+	ErrEmptySlot = errors.New("file slot is empty")
+	// ErrNoSpace is returned by Replace when the new contents do not fit in the file's slot.
+	ErrNoSpace = errors.New("file does not fit in its slot")
+	// ErrEmptyData is returned by Replace when the new contents are empty, which the
+	// file table cannot tell from a deleted file; Delete clears a slot.
+	ErrEmptyData = errors.New("replacement is empty")
+)
+
+// Candidate is a header offset tried by Parse along with the magic found there.
+type Candidate struct {
+	Offset int64
+	// Magic holds the bytes at Offset, fewer than four if the image ends before
+	Magic []byte
+}
+
+// NoHeaderError is returned by Parse when no candidate holds a valid header. It lists
+// what was found at each offset and matches ErrNoHeader with errors.Is.
+type NoHeaderError struct {
+	Candidates []Candidate
+}
+
+func (e *NoHeaderError) Error() string { return ErrNoHeader.Error() }
+
+func (e *NoHeaderError) Unwrap() error { return ErrNoHeader }
+
+// Options control how an image is interpreted. Zero values select the defaults.
+type Options struct {
+	// BlockSize is the unit of offsets and lengths in the file table.
+	BlockSize int64
+	// HeaderSize is the size of the region preceding the SBFS header, NorHeaderSize
+	// if zero unless ExplicitHeaderSize is set.
+	HeaderSize int64
+	// ExplicitHeaderSize keeps a HeaderSize of zero, for images starting with the
+	// SBFS header.
+	ExplicitHeaderSize bool
+	// Base is the offset of the SBFS region within a larger dump. The default
+	// HeaderOffsets and the file table offsets count from it; the offsets of an
+	// Image are positions in the reader.
+	Base int64
+	// HeaderOffsets are absolute offsets scanned before the default HeaderOffsets,
+	// in ascending order with duplicates ignored. They do not count from Base.
+	HeaderOffsets []int64
+	// OnlyHeaderOffsets skips the default HeaderOffsets, only HeaderOffsets are tried.
+	OnlyHeaderOffsets bool
+	// Checksum is the algorithm used for the header checksum, SHA256 by default.
+	Checksum Checksummer
+	// ChecksumScope selects what the checksum covers, the header only by default.
+	ChecksumScope ChecksumScope
+	// ChecksumPos selects where the checksum is stored, after the file table by default.
+	ChecksumPos ChecksumPosition
+	// ByteOrder of the file table fields, little-endian by default.
+	ByteOrder binary.ByteOrder
+	// LayoutSlots adds to or overrides the entries of LayoutSlots.
+	LayoutSlots map[byte]int
+	// Magic is the expected 4-byte magic as stored, also accepted reversed, Magic by default.
+	Magic string
+	// Log receives diagnostics, nothing is logged if it is nil.
+	Log Logger
+}
+
+// Logger is a leveled diagnostic logger. Level 1 covers header scanning and
+// checksums, level 2 adds every copy of image data.
+type Logger func(level int, format string, v ...any)
+
+func (o Options) logf(level int, format string, v ...any) {
+	if o.Log != nil {
+		o.Log(level, format, v...)
+	}
+}
+
+func (o Options) withDefaults() Options {
+	if o.BlockSize == 0 {
+		o.BlockSize = BlockSize
+	}
+	if o.HeaderSize == 0 && !o.ExplicitHeaderSize {
+		o.HeaderSize = NorHeaderSize
+	}
+	if o.Checksum == nil {
+		o.Checksum = SHA256
+	}
+	if o.ByteOrder == nil {
+		o.ByteOrder = binary.LittleEndian
+	}
+	if o.Magic == "" {
+		o.Magic = Magic
+	}
+	return o
+}
+
+// Validate checks that the options describe a usable layout.
+func (o Options) Validate() error {
+	o = o.withDefaults()
+	if o.BlockSize < 0 || o.BlockSize&(o.BlockSize-1) != 0 {
+		return fmt.Errorf("block size 0x%X is not a power of two", o.BlockSize)
+	}
+	// offsets and lengths are 32-bit block counts, their byte values must fit an int64
+	if o.BlockSize > math.MaxInt64/math.MaxUint32 {
+		return fmt.Errorf("block size 0x%X is too large", o.BlockSize)
+	}
+	if o.HeaderSize < 0 {
+		return fmt.Errorf("invalid header size 0x%X", o.HeaderSize)
+	}
+	if o.Base < 0 {
+		return fmt.Errorf("invalid base 0x%X", o.Base)
+	}
+	if len(o.Magic) != len(Magic) {
+		return fmt.Errorf("invalid magic %q: must be %d bytes", o.Magic, len(Magic))
+	}
+	if o.OnlyHeaderOffsets && len(o.HeaderOffsets) == 0 {
+		return errors.New("no header offsets to try")
+	}
+	return nil
+}
+
+// candidates returns the header offsets to scan in order: the sorted HeaderOffsets of
+// o, then the default ones not already among them.
+func (o Options) candidates() []int64 {
+	offsets := slices.Clone(o.HeaderOffsets)
+	slices.Sort(offsets)
+	offsets = slices.Compact(offsets)
+	if o.OnlyHeaderOffsets {
+		return offsets
+	}
+	user := len(offsets)
+	for _, off := range HeaderOffsets {
+		off += o.Base + o.HeaderSize - NorHeaderSize
+		if !slices.Contains(offsets[:user], off) {
+			offsets = append(offsets, off)
+		}
+	}
+	return offsets
+}
+
+// FileInfo describes a single entry of the file table with offset and length in bytes.
+type FileInfo struct {
+	Index  int
+	Offset int64
+	Length int64
+}
+
+// Image is a parsed SBFS image backed by an io.ReaderAt.
+type Image struct {
+	r    io.ReaderAt
+	opts Options
+	// data written over the original contents by WriteTo
+	patches []patch
+
+	// Size is the size of the underlying image or -1 if it could not be determined.
+	Size int64
+	// HeaderOffset is the offset in the image at which the header was found.
+	HeaderOffset int64
+	// MagicReversed reports whether the magic is stored as Options.Magic rather than in order.
+	MagicReversed bool
+	Header        HeaderWithSha
+	// Files holds one entry per slot of the file table, including empty ones.
+	Files []FileInfo
+}
+
+// patch replaces the bytes of the image at offset with data.
+type patch struct {
+	offset int64
+	data   []byte
+}
+
+// FileName returns the name of file table slot i, synthesizing one for slots
+// without a known name.
+func FileName(i int) string {
+	if i < len(FileNames) && FileNames[i] != "" {
+		return FileNames[i]
+	}
+	return fmt.Sprintf("file_%02d.bin", i)
+}
+
+// FileIndex returns the file table slot of name or -1 if the name is unknown.
+// Names synthesized by FileName are recognized as well.
+func FileIndex(name string) int {
+	for i, n := range FileNames {
+		if n != "" && n == name {
+			return i
+		}
+	}
+	var i int
+	if _, err := fmt.Sscanf(name, "file_%02d.bin", &i); err == nil && i >= 0 && FileName(i) == name {
+		return i
+	}
+	return -1
+}
+
+// ReadFileNames reads a list of file names for FileNames from r, one per line in
+// slot order. Blank lines leave a slot unnamed. Surrounding white space, including
+// the \r of files edited on Windows, is trimmed.
+func ReadFileNames(r io.Reader) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		name := strings.TrimSpace(sc.Text())
+		if len(names) == NumFiles {
+			if name == "" {
+				continue
+			}
+			return nil, fmt.Errorf("more than %d file names", NumFiles)
+		}
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("line %d: invalid file name %q", len(names)+1, name)
+		}
+		if name != "" && seen[name] {
+			return nil, fmt.Errorf("line %d: duplicate file name %q", len(names)+1, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, sc.Err()
+}
+
+// reverseMagic returns m with the byte order reversed.
+func reverseMagic(m string) string {
+	b := []byte(m)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// readerSize returns the size of r if it exposes one, -1 otherwise.
+func readerSize(r io.ReaderAt) int64 {
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return v.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := v.Stat(); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// Parse scans HeaderOffsets for a valid header and returns the parsed image.
+func Parse(r io.ReaderAt) (*Image, error) {
+	return ParseWithOptions(r, Options{})
+}
+
+// ParseWithOptions is like Parse but interprets the image according to opts.
+// opts.HeaderOffsets are tried first, then HeaderOffsets shifted by opts.Base and the
+// difference between opts.HeaderSize and NorHeaderSize.
+func ParseWithOptions(r io.ReaderAt, opts Options) (*Image, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	rejected := &NoHeaderError{}
+	for _, off := range opts.candidates() {
+		h, c, err := headerAt(r, off, opts)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			return newImage(r, opts, h, off), nil
+		}
+		rejected.Candidates = append(rejected.Candidates, *c)
+	}
+	return nil, rejected
+}
+
+// ScanHeaders parses the header at every candidate offset of opts, as tried by
+// ParseWithOptions, and returns an image for each one holding a valid magic, in
+// candidate order. Unlike ParseWithOptions it does not stop at the first, which
+// helps with dumps holding stale copies of the header.
+func ScanHeaders(r io.ReaderAt, opts Options) ([]*Image, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	var imgs []*Image
+	seen := map[int64]bool{}
+	for _, off := range opts.candidates() {
+		if seen[off] {
+			continue
+		}
+		seen[off] = true
+		h, _, err := headerAt(r, off, opts)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			imgs = append(imgs, newImage(r, opts, h, off))
+		}
+	}
+	return imgs, nil
+}
+
+// headerAt reads the header at off. If off holds no header with a valid magic the
+// header is nil and the candidate describes what was found instead.
+func headerAt(r io.ReaderAt, off int64, opts Options) (*HeaderWithSha, *Candidate, error) {
+	opts.logf(1, "trying header offset 0x%06X", off)
+	h, err := ReadHeader(io.NewSectionReader(r, off, math.MaxInt64-off), opts)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// too close to the end of the image to hold a header
+		opts.logf(1, "no header at 0x%06X: %v", off, err)
+		magic := make([]byte, len(opts.Magic))
+		n, _ := r.ReadAt(magic, off)
+		return nil, &Candidate{Offset: off, Magic: magic[:n]}, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("reading header at 0x%06X: %w", off, err)
+	}
+	// check if it's actual header, in either byte order
+	opts.logf(1, "magic at 0x%06X: % X %q", off, h.Header.Magic[:], string(h.Header.Magic[:]))
+	if !h.Header.HasValidMagic(opts.Magic) {
+		return nil, &Candidate{Offset: off, Magic: append([]byte{}, h.Header.Magic[:]...)}, nil
+	}
+	return h, nil, nil
+}
+
+// newImage returns the image described by the header h found at off. With
+// ChecksumAuto the header is read again with the checksum before the file table if
+// only that verifies.
+func newImage(r io.ReaderAt, opts Options, h *HeaderWithSha, off int64) *Image {
+	if opts.ChecksumPos == ChecksumAuto {
+		opts.ChecksumPos = ChecksumAfter
+		img := newImage(r, opts, h, off)
+		if img.Verify() == nil {
+			return img
+		}
+		opts.ChecksumPos = ChecksumBefore
+		hb, err := ReadHeader(io.NewSectionReader(r, off, math.MaxInt64-off), opts)
+		if err != nil {
+			return img
+		}
+		if before := newImage(r, opts, hb, off); before.Verify() == nil {
+			opts.logf(1, "checksum at 0x%06X verifies before the file table", off)
+			return before
+		}
+		return img
+	}
+	img := &Image{r: r, opts: opts, Size: readerSize(r), Header: *h, HeaderOffset: off}
+	img.MagicReversed = string(h.Header.Magic[:]) == opts.Magic
+	img.Files = make([]FileInfo, 0, len(h.Header.Files))
+	for i, f := range h.Header.Files {
+		img.Files = append(img.Files, FileInfo{
+			Index:  i,
+			Offset: opts.Base + int64(f.Offset)*opts.BlockSize,
+			Length: int64(f.Length) * opts.BlockSize,
+		})
+	}
+	return img
+}
+
+// HeaderBytes returns the header as WriteTo stores it, checksum included.
+func (img *Image) HeaderBytes() []byte {
+	return img.Header.EncodeWith(img.opts)
+}
+
+// Options returns the options the image was parsed with, with defaults filled in.
+func (img *Image) Options() Options {
+	return img.opts
+}
+
+// CheckBounds returns ErrOutOfBounds if f does not fit within the image.
+// Images of unknown size are not checked.
+func (img *Image) CheckBounds(f FileInfo) error {
+	if img.Size >= 0 && f.Offset+f.Length > img.Size {
+		return fmt.Errorf("%w: 0x%06X-0x%06X, image size 0x%06X", ErrOutOfBounds, f.Offset, f.Offset+f.Length, img.Size)
+	}
+	return nil
+}
+
+// HeaderSection returns a reader over the on-disk bytes of the header, checksum included.
+func (img *Image) HeaderSection() *io.SectionReader {
+	return io.NewSectionReader(img.r, img.HeaderOffset, int64(img.Header.Size()))
+}
+
+// KnownVersion reports whether the versions of the header are in KnownVersions, or
+// its layout is described by the LayoutSlots of the options.
+func (img *Image) KnownVersion() bool {
+	h := img.Header.Header
+	if _, ok := img.opts.LayoutSlots[h.LayoutVersion]; ok {
+		return true
+	}
+	return slices.Contains(KnownVersions, Version{Format: h.FormatVersion, Layout: h.LayoutVersion})
+}
+
+// UnknownLength returns the byte count some boards store in the first 4 bytes of the
+// Unknown field of a file table entry, the length of f without block padding. ok is
+// false if the value is zero or exceeds the length of f.
+func (img *Image) UnknownLength(f FileInfo) (length int64, ok bool) {
+	entry := img.Header.Header.Files[f.Index]
+	length = int64(img.opts.ByteOrder.Uint32(entry.Unknown[:4]))
+	return length, length != 0 && length <= f.Length
+}
+
+// Section returns a reader over the contents of f. The section never extends past
+// the end of the image, so the file table cannot make it read more than is there.
+func (img *Image) Section(f FileInfo) *io.SectionReader {
+	length := f.Length
+	if img.Size >= 0 {
+		length = max(0, min(length, img.Size-f.Offset))
+	}
+	return io.NewSectionReader(img.r, f.Offset, length)
+}
+
+// Open returns a reader over the contents of the named file without copying them.
+// It returns ErrUnknownFile if the image has no slot of that name, ErrEmptySlot if the
+// slot is unused and ErrOutOfBounds if the file extends past the end of the image.
+func (img *Image) Open(name string) (io.ReadSeeker, error) {
+	i := FileIndex(name)
+	if i < 0 || i >= len(img.Files) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFile, name)
+	}
+	f := img.Files[i]
+	if f.Length == 0x00 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptySlot, name)
+	}
+	if err := img.CheckBounds(f); err != nil {
+		return nil, err
+	}
+	return img.Section(f), nil
+}
+
+// SlotSize returns the number of bytes available to file i, which is the space up to
+// the next file in the image or, for the last file, up to the end of the image.
+func (img *Image) SlotSize(i int) int64 {
+	f := img.Files[i]
+	end := img.Size
+	if end < 0 {
+		end = f.Offset + f.Length
+	}
+	for _, o := range img.Files {
+		if o.Length != 0x00 && o.Offset > f.Offset && o.Offset < end {
+			end = o.Offset
+		}
+	}
+	return end - f.Offset
+}
+
+// Replace stores data as the new contents of file i. The data is padded with 0xFF up to
+// the next block boundary and must fit in the slot returned by SlotSize. The file table
+// is updated but the checksum is left to the caller. Empty data is rejected with
+// ErrEmptyData, use Delete to remove a file.
+func (img *Image) Replace(i int, data []byte) error {
+	f := img.Files[i]
+	if f.Length == 0x00 {
+		return ErrEmptySlot
+	}
+	if len(data) == 0 {
+		return ErrEmptyData
+	}
+	bs := img.opts.BlockSize
+	blocks := (int64(len(data)) + bs - 1) / bs
+	if blocks*bs > img.SlotSize(i) {
+		return fmt.Errorf("%w: need 0x%06X bytes, have 0x%06X", ErrNoSpace, blocks*bs, img.SlotSize(i))
+	}
+	padded := bytes.Repeat([]byte{0xFF}, int(blocks*bs))
+	copy(padded, data)
+
+	img.patches = append(img.patches, patch{offset: f.Offset, data: padded})
+	img.Header.Header.Files[i].Length = uint32(blocks)
+	img.Files[i].Length = blocks * bs
+	return nil
+}
+
+// Fill sets the bytes of r to b when the image is written. The range must not overlap
+// the header or the files, whose contents the checksum may cover; WriteTo fails on
+// overlapping writes.
+func (img *Image) Fill(r Range, b byte) {
+	img.patches = append(img.patches, patch{offset: r.Start, data: bytes.Repeat([]byte{b}, int(r.End-r.Start))})
+}
+
+// Delete clears the file table entry of file i, including its unknown bytes. The
+// file's contents are left in place and the checksum is left to the caller.
+func (img *Image) Delete(i int) error {
+	if img.Files[i].Length == 0x00 {
+		return ErrEmptySlot
+	}
+	img.Header.Header.Files[i] = File{}
+	img.Files[i].Offset, img.Files[i].Length = 0, 0
+	return nil
+}
+
+// PatchHeader overwrites the header as stored, see HeaderBytes, with data at offset
+// off and parses the result back into the header and file table. The patch must lie
+// within the header, must not touch the checksum and must not change the number of
+// file table slots through the layout version. The checksum is left to the caller.
+func (img *Image) PatchHeader(off int, data []byte) error {
+	raw := img.HeaderBytes()
+	sum := img.Header.checksumOffset(img.opts)
+	if off < 0 || off+len(data) > len(raw) {
+		return fmt.Errorf("patch 0x%X-0x%X outside of the 0x%X byte header", off, off+len(data), len(raw))
+	}
+	if off < sum+ChecksumSize && off+len(data) > sum {
+		return fmt.Errorf("patch 0x%X-0x%X overlaps the checksum at 0x%X-0x%X", off, off+len(data), sum, sum+ChecksumSize)
+	}
+	copy(raw[off:], data)
+	// reading from memory only fails if the layout version now selects more slots
+	h, err := ReadHeader(bytes.NewReader(raw), img.opts)
+	if err != nil || len(h.Header.Files) != len(img.Files) {
+		return fmt.Errorf("patched layout version must keep the %d file table slots", len(img.Files))
+	}
+	img.Header = *h
+	for i, f := range h.Header.Files {
+		img.Files[i].Offset = img.opts.Base + int64(f.Offset)*img.opts.BlockSize
+		img.Files[i].Length = int64(f.Length) * img.opts.BlockSize
+	}
+	return nil
+}
+
+// ComputeChecksum computes the checksum over scope of the current header, encoded in
+// the byte order of the options, with the algorithm selected in the options. It is
+// the value UpdateChecksum stores and Verify expects for the scope of the options.
+// Only the full scope reads from the image and can fail.
+func (img *Image) ComputeChecksum(scope ChecksumScope) ([32]byte, error) {
+	data := img.Header.Header.Encode(img.opts.ByteOrder)
+	if scope == ScopeFull {
+		for _, f := range img.Files {
+			if f.Length == 0x00 {
+				continue
+			}
+			contents, err := img.contents(f)
+			if err != nil {
+				return [32]byte{}, fmt.Errorf("%s: %w", FileName(f.Index), err)
+			}
+			data = append(data, contents...)
+		}
+	}
+	return img.opts.Checksum.Sum(data), nil
+}
+
+// contents returns the current contents of f, including changes made by Replace.
+func (img *Image) contents(f FileInfo) ([]byte, error) {
+	for i := len(img.patches) - 1; i >= 0; i-- {
+		if p := img.patches[i]; p.offset == f.Offset && int64(len(p.data)) == f.Length {
+			return p.data, nil
+		}
+	}
+	if err := img.CheckBounds(f); err != nil {
+		return nil, err
+	}
+	// read instead of allocating f.Length up front, images of unknown size are not
+	// bounds checked and the length may be bogus
+	data, err := io.ReadAll(img.Section(f))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != f.Length {
+		return nil, fmt.Errorf("%w: 0x%06X-0x%06X, image ends at 0x%06X", ErrOutOfBounds, f.Offset, f.Offset+f.Length, f.Offset+int64(len(data)))
+	}
+	return data, nil
+}
+
+// UpdateChecksum recomputes the stored checksum from the current header.
+func (img *Image) UpdateChecksum() error {
+	sum, err := img.ComputeChecksum(img.opts.ChecksumScope)
+	if err != nil {
+		return err
+	}
+	img.opts.logf(1, "checksum: stored %X, computed %X", img.Header.Checksum, sum)
+	img.Header.Checksum = sum
+	return nil
+}
+
+// Verify compares the stored checksum against the one computed from the header.
+func (img *Image) Verify() error {
+	if img.Header.Checksum == [32]byte{} {
+		return ErrNoChecksum
+	}
+	sum, err := img.ComputeChecksum(img.opts.ChecksumScope)
+	if err != nil {
+		return err
+	}
+	img.opts.logf(1, "checksum: stored %X, computed %X", img.Header.Checksum, sum)
+	if img.Header.Checksum != sum {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+// WriteTo writes the whole image to w, replacing the on-disk header with img.Header
+// and the contents of any file passed to Replace.
+func (img *Image) WriteTo(w io.Writer) (int64, error) {
+	// the header replaces exactly the on-disk one, whose size follows from the
+	// structs, so a serialization bug cannot shift the data after it
+	size := int64(img.Header.Size())
+	header := img.HeaderBytes()
+	if int64(len(header)) != size {
+		return 0, fmt.Errorf("serialized header is 0x%X bytes, expected 0x%X", len(header), size)
+	}
+	patches := append([]patch{{offset: img.HeaderOffset, data: header}}, img.patches...)
+	sort.SliceStable(patches, func(i, j int) bool { return patches[i].offset < patches[j].offset })
+
+	// one buffer for every copy, io.Copy allocates one per call unless w implements
+	// io.ReaderFrom, and files only do so for other files
+	var written, pos int64
+	buf := make([]byte, 32*1024)
+	// the log arguments are allocated even without a logger
+	logging := img.opts.Log != nil
+	copyTo := func(end int64) error {
+		if logging {
+			img.opts.logf(2, "copying 0x%06X-0x%06X", pos, end)
+		}
+		for off := pos; off < end; {
+			n, err := img.r.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			off += int64(n)
+			if werr != nil {
+				return werr
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, p := range patches {
+		if p.offset < pos {
+			return written, fmt.Errorf("overlapping writes at 0x%06X", p.offset)
+		}
+		if err := copyTo(p.offset); err != nil {
+			return written, err
+		}
+		if logging {
+			img.opts.logf(2, "writing 0x%06X-0x%06X", p.offset, p.offset+int64(len(p.data)))
+		}
+		m, err := w.Write(p.data)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		pos = p.offset + int64(len(p.data))
+	}
+	// copy the rest of the image
+	end := img.Size
+	if end < 0 {
+		end = math.MaxInt64
+	}
+	return written, copyTo(max(end, pos))
+}
@@ -0,0 +1,698 @@
+package sbfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testFiles is a small file table used by most tests.
+var testFiles = []File{
+	{Offset: 0x20, Length: 2, Unknown: [8]byte{1, 2, 3, 4}},
+	{Offset: 0x22, Length: 1},
+	{},
+	{Offset: 0x23, Length: 1},
+	{}, {}, {}, {}, {}, {}, {}, {},
+}
+
+// fileContents returns the contents newTestImage gives to slot i.
+func fileContents(i int, length int64) []byte {
+	return bytes.Repeat([]byte{byte(0xA0 + i)}, int(length))
+}
+
+// newTestImage returns an image of size bytes with a valid header at offset holding
+// files, whose contents are filled in by fileContents.
+func newTestImage(t testing.TB, size, offset int64, files []File) []byte {
+	t.Helper()
+	var h HeaderWithSha
+	copy(h.Header.Magic[:], Magic)
+	h.Header.Files = files
+	h.Checksum = h.Header.Checksum()
+
+	data := make([]byte, size)
+	copy(data[offset:], h.Bytes())
+	for i, f := range files {
+		off, length := int64(f.Offset)*BlockSize, int64(f.Length)*BlockSize
+		if off+length <= size {
+			copy(data[off:off+length], fileContents(i, length))
+		}
+	}
+	return data
+}
+
+func TestParseHeaderOffsets(t *testing.T) {
+	for _, offset := range HeaderOffsets {
+		data := newTestImage(t, 0x30000, offset, testFiles)
+		img, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("header at 0x%X: %v", offset, err)
+		}
+		if img.HeaderOffset != offset {
+			t.Errorf("HeaderOffset = 0x%X, want 0x%X", img.HeaderOffset, offset)
+		}
+		if img.Size != int64(len(data)) {
+			t.Errorf("Size = 0x%X, want 0x%X", img.Size, len(data))
+		}
+		if err = img.Verify(); err != nil {
+			t.Errorf("header at 0x%X: %v", offset, err)
+		}
+	}
+}
+
+func TestOnlyHeaderOffsets(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	_, err := ParseWithOptions(bytes.NewReader(data), Options{HeaderOffsets: []int64{0x11000}, OnlyHeaderOffsets: true})
+	var rejected *NoHeaderError
+	if !errors.As(err, &rejected) || len(rejected.Candidates) != 1 {
+		t.Fatalf("err = %v, want ErrNoHeader for the single offset", err)
+	}
+	img, err := ParseWithOptions(bytes.NewReader(data), Options{HeaderOffsets: []int64{0x10000}, OnlyHeaderOffsets: true})
+	if err != nil || img.HeaderOffset != 0x10000 {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestBase(t *testing.T) {
+	nor := newTestImage(t, 0x30000, 0x10000, testFiles)
+	data := append(make([]byte, 0x200000), nor...)
+	img, err := ParseWithOptions(bytes.NewReader(data), Options{Base: 0x200000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.HeaderOffset != 0x210000 {
+		t.Errorf("header at 0x%X, want 0x210000", img.HeaderOffset)
+	}
+	for _, f := range img.Files {
+		if f.Length == 0x00 {
+			continue
+		}
+		got, err := io.ReadAll(img.Section(f))
+		if err != nil || !bytes.Equal(got, fileContents(f.Index, f.Length)) {
+			t.Errorf("%s at 0x%X: err = %v, contents differ", FileName(f.Index), f.Offset, err)
+		}
+	}
+}
+
+func TestZeroHeaderSize(t *testing.T) {
+	data := newTestImage(t, 0x20000, 0x0, testFiles)
+	if _, err := ParseWithOptions(bytes.NewReader(data), Options{}); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("default header size: err = %v, want ErrNoHeader", err)
+	}
+	img, err := ParseWithOptions(bytes.NewReader(data), Options{ExplicitHeaderSize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.HeaderOffset != 0 {
+		t.Errorf("header at 0x%X, want 0", img.HeaderOffset)
+	}
+}
+
+func TestCopyBank(t *testing.T) {
+	// bank B is bank A moved up by 0x20000, with other contents in its first file
+	filesB := slices.Clone(testFiles)
+	for i := range filesB {
+		if filesB[i].Length != 0 {
+			filesB[i].Offset += 0x20
+		}
+	}
+	data := newTestImage(t, 0x50000, 0x10000, testFiles)
+	copy(data[0x30000:], newTestImage(t, 0x50000, 0x30000, filesB)[0x30000:])
+	copy(data[0x40000:0x42000], bytes.Repeat([]byte{0x5A}, 0x2000))
+	data[0x30000+5] = 0x08 // sequence number of B, the header scope checksum is not checked
+
+	banks, err := ParseBanks(bytes.NewReader(data), Options{})
+	if err != nil || len(banks) != 2 {
+		t.Fatalf("%d banks, err = %v", len(banks), err)
+	}
+	if err = CopyBank(banks[1], banks[0]); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err = banks[0].WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Parse(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = img.Verify(); err != nil {
+		t.Errorf("copied header: %v", err)
+	}
+	if seq := img.Header.Header.SequenceNumber; seq != 0x09 {
+		t.Errorf("sequence number 0x%02X, want 0x09", seq)
+	}
+	f := img.Files[0]
+	got, err := io.ReadAll(img.Section(f))
+	if err != nil || f.Offset != 0x20000 || !bytes.Equal(got, bytes.Repeat([]byte{0x5A}, 0x2000)) {
+		t.Errorf("%s at 0x%X: err = %v, contents differ from bank B", FileName(0), f.Offset, err)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	opts := Options{HeaderOffsets: []int64{0x30000, 0x10000, 0x30000}, HeaderSize: NorHeaderSize}
+	got := opts.candidates()
+	want := []int64{0x10000, 0x30000, 0x11000}
+	if !slices.Equal(got, want) {
+		t.Fatalf("candidates = %#x, want %#x", got, want)
+	}
+}
+
+func TestParseNoHeader(t *testing.T) {
+	_, err := Parse(bytes.NewReader(make([]byte, 0x30000)))
+	if !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("err = %v, want ErrNoHeader", err)
+	}
+}
+
+func TestScanHeaders(t *testing.T) {
+	// a stale copy of the header at the second default offset
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	stale := newTestImage(t, 0x30000, 0x11000, testFiles)
+	copy(data[0x11000:0x12000], stale[0x11000:0x12000])
+	data[0x11000+5] = 0x06 // sequence number, invalidating the checksum
+
+	imgs, err := ScanHeaders(bytes.NewReader(data), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 {
+		t.Fatalf("found %d headers, want 2", len(imgs))
+	}
+	if imgs[0].HeaderOffset != 0x10000 || imgs[0].Verify() != nil {
+		t.Errorf("first header at 0x%X, Verify() = %v", imgs[0].HeaderOffset, imgs[0].Verify())
+	}
+	if imgs[1].HeaderOffset != 0x11000 || !errors.Is(imgs[1].Verify(), ErrBadChecksum) {
+		t.Errorf("second header at 0x%X, Verify() = %v", imgs[1].HeaderOffset, imgs[1].Verify())
+	}
+	if got := imgs[1].Header.Header.SequenceNumber; got != 0x06 {
+		t.Errorf("second sequence number = 0x%02X, want 0x06", got)
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	if got := binary.Size(headerPrefix{}); got != HeaderPrefixSize {
+		t.Errorf("header prefix is 0x%X bytes, want 0x%X", got, HeaderPrefixSize)
+	}
+	if got := binary.Size(File{}); got != FileEntrySize {
+		t.Errorf("file table entry is 0x%X bytes, want 0x%X", got, FileEntrySize)
+	}
+	h := HeaderWithSha{Header: Header{Files: make([]File, NumFiles)}}
+	if got := binary.Size(h.Checksum); got != ChecksumSize {
+		t.Errorf("checksum is 0x%X bytes, want 0x%X", got, ChecksumSize)
+	}
+	if got := h.Size(); got != DefaultHeaderSize {
+		t.Errorf("Size() = 0x%X, want 0x%X", got, DefaultHeaderSize)
+	}
+	if got := len(h.Bytes()); got != DefaultHeaderSize {
+		t.Errorf("Bytes() is 0x%X bytes, want 0x%X", got, DefaultHeaderSize)
+	}
+}
+
+func TestEncodeMatchesStructs(t *testing.T) {
+	h := HeaderWithSha{Header: Header{
+		Magic:          [4]byte{'S', 'B', 'F', 'S'},
+		FormatVersion:  0x01,
+		SequenceNumber: 0x02,
+		LayoutVersion:  0x03,
+		Unknown1:       0x04,
+		Unknown2:       [24]byte{5, 6, 7},
+		Files:          testFiles,
+	}, Checksum: [32]byte{0xAA, 0xBB}}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		want := new(bytes.Buffer)
+		binary.Write(want, order, headerPrefix{
+			Magic:          h.Header.Magic,
+			FormatVersion:  h.Header.FormatVersion,
+			SequenceNumber: h.Header.SequenceNumber,
+			LayoutVersion:  h.Header.LayoutVersion,
+			Unknown1:       h.Header.Unknown1,
+			Unknown2:       h.Header.Unknown2,
+		})
+		binary.Write(want, order, h.Header.Files)
+		binary.Write(want, order, h.Checksum)
+		got := h.Encode(order)
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("%v: Encode() = % X, want % X", order, got, want.Bytes())
+		}
+		back, err := ReadHeader(bytes.NewReader(got), Options{ByteOrder: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*back, h) {
+			t.Errorf("%v: ReadHeader() = %+v, want %+v", order, *back, h)
+		}
+	}
+}
+
+func TestHasValidMagic(t *testing.T) {
+	for _, tt := range []struct {
+		magic    string
+		expected string
+		want     bool
+	}{
+		{"SFBS", "", true},
+		{"SBFS", "", true},
+		{"SFB\x00", "", false},
+		{"\x00SFB", "", false},
+		{"sfbs", "", false},
+		{"\x00\x00\x00\x00", "", false},
+		{"ABCD", "ABCD", true},
+		{"DCBA", "ABCD", true},
+		{"SFBS", "ABCD", false},
+	} {
+		var h Header
+		copy(h.Magic[:], tt.magic)
+		if got := h.HasValidMagic(tt.expected); got != tt.want {
+			t.Errorf("HasValidMagic(%q) of %q = %v, want %v", tt.expected, tt.magic, got, tt.want)
+		}
+	}
+}
+
+func TestOptionsMagic(t *testing.T) {
+	var h HeaderWithSha
+	copy(h.Header.Magic[:], "DCBA")
+	h.Header.Files = testFiles
+	h.Checksum = h.Header.Checksum()
+	data := make([]byte, 0x30000)
+	copy(data[0x10000:], h.Bytes())
+
+	if _, err := Parse(bytes.NewReader(data)); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("Parse() error = %v, want ErrNoHeader", err)
+	}
+	img, err := ParseWithOptions(bytes.NewReader(data), Options{Magic: "ABCD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.HeaderOffset != 0x10000 || img.MagicReversed {
+		t.Errorf("HeaderOffset = 0x%X, MagicReversed = %v, want 0x10000, false", img.HeaderOffset, img.MagicReversed)
+	}
+	if err := img.Verify(); err != nil {
+		t.Error(err)
+	}
+	if Magic != "SFBS" {
+		t.Errorf("Magic = %q, changed by ParseWithOptions", Magic)
+	}
+	if err := (Options{Magic: "ABC"}).Validate(); err == nil {
+		t.Error("Validate() accepted a 3-byte magic")
+	}
+}
+
+func TestSection(t *testing.T) {
+	img, err := Parse(bytes.NewReader(newTestImage(t, 0x30000, 0x10000, testFiles)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range img.Files {
+		if f.Length == 0x00 {
+			continue
+		}
+		got, err := io.ReadAll(img.Section(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, fileContents(f.Index, f.Length)) {
+			t.Errorf("%s: wrong contents", FileName(f.Index))
+		}
+	}
+}
+
+// sizelessReader hides the size of the image from Parse.
+type sizelessReader struct {
+	r io.ReaderAt
+}
+
+func (s sizelessReader) ReadAt(p []byte, off int64) (int, error) { return s.r.ReadAt(p, off) }
+
+func TestHugeLength(t *testing.T) {
+	files := append([]File{{Offset: 0x20, Length: math.MaxUint32}}, testFiles[1:]...)
+	data := newTestImage(t, 0x30000, 0x10000, files)
+	img, err := ParseWithOptions(bytes.NewReader(data), Options{ChecksumScope: ScopeFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := img.Files[0]
+	if err = img.CheckBounds(f); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("CheckBounds: err = %v, want ErrOutOfBounds", err)
+	}
+	if _, err = img.Open(FileName(0)); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("Open: err = %v, want ErrOutOfBounds", err)
+	}
+	if got, want := img.Section(f).Size(), img.Size-f.Offset; got != want {
+		t.Errorf("Section size = 0x%X, want 0x%X", got, want)
+	}
+	if _, err = img.ComputeChecksum(ScopeFull); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("ComputeChecksum: err = %v, want ErrOutOfBounds", err)
+	}
+
+	// without a known size nothing is bounds checked up front, reading must still stop
+	// at the end of the data instead of trusting the length
+	img, err = ParseWithOptions(sizelessReader{bytes.NewReader(data)}, Options{ChecksumScope: ScopeFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = img.ComputeChecksum(ScopeFull); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("ComputeChecksum of unknown size: err = %v, want ErrOutOfBounds", err)
+	}
+}
+
+func TestInjectRoundTrip(t *testing.T) {
+	img, err := Parse(bytes.NewReader(newTestImage(t, 0x30000, 0x11000, testFiles)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Header.Header.SequenceNumber = 0x42
+	if err = img.UpdateChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err = img.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	img2, err := Parse(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = img2.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if img2.HeaderOffset != img.HeaderOffset || !reflect.DeepEqual(img2.Header, img.Header) {
+		t.Errorf("header did not round-trip: got %+v at 0x%X", img2.Header, img2.HeaderOffset)
+	}
+}
+
+func TestParseAllSlots(t *testing.T) {
+	files := make([]File, NumFiles)
+	for i := range files {
+		files[i] = File{Offset: uint32(0x20 + i), Length: 1}
+	}
+	data := newTestImage(t, 0x40000, 0x10000, files)
+
+	img, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, f := range img.Files {
+		name := FileName(f.Index)
+		if name == "" || seen[name] {
+			t.Errorf("slot %d: bad or duplicate name %q", f.Index, name)
+		}
+		seen[name] = true
+		if got := FileIndex(name); got != f.Index {
+			t.Errorf("FileIndex(%q) = %d, want %d", name, got, f.Index)
+		}
+	}
+	if got := FileName(6); got != "file_06.bin" {
+		t.Errorf("FileName(6) = %q", got)
+	}
+}
+
+func TestWriteToPreservesSurroundingBytes(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	hdrSize := 0x100
+	// fill everything but the header with a recognizable pattern
+	for i := range data {
+		if i < 0x10000 || i >= 0x10000+hdrSize {
+			data[i] = byte(i * 7)
+		}
+	}
+
+	img, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Header.Header.SequenceNumber++
+	if err = img.UpdateChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err = img.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.Bytes()
+	if len(got) != len(data) {
+		t.Fatalf("output size 0x%X, want 0x%X", len(got), len(data))
+	}
+	if !bytes.Equal(got[:0x10000], data[:0x10000]) {
+		t.Error("region before the header changed")
+	}
+	if !bytes.Equal(got[0x10000+hdrSize:], data[0x10000+hdrSize:]) {
+		t.Error("region after the header changed")
+	}
+}
+
+func TestFillUnclaimed(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	img, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gaps := img.Unclaimed()
+	for _, g := range gaps {
+		img.Fill(g, 0xEE)
+	}
+	out := new(bytes.Buffer)
+	if _, err = img.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.Bytes()
+	for _, g := range gaps {
+		if !bytes.Equal(got[g.Start:g.End], bytes.Repeat([]byte{0xEE}, int(g.End-g.Start))) {
+			t.Errorf("gap 0x%X-0x%X not filled", g.Start, g.End)
+		}
+	}
+	for _, f := range img.Files {
+		if !bytes.Equal(got[f.Offset:f.Offset+f.Length], data[f.Offset:f.Offset+f.Length]) {
+			t.Errorf("%s changed", FileName(f.Index))
+		}
+	}
+}
+
+func TestLayoutSlots(t *testing.T) {
+	files := make([]File, 16)
+	files[15] = File{Offset: 0x20, Length: 1}
+	data := newTestImage(t, 0x30000, 0x10000, files)
+	// layout version is at offset 6 of the header
+	data[0x10006] = 0x42
+
+	opts := Options{LayoutSlots: map[byte]int{0x42: 16}}
+	img, err := ParseWithOptions(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Files) != 16 || img.Files[15].Offset != 0x20000 {
+		t.Fatalf("got %d files, last %+v", len(img.Files), img.Files[len(img.Files)-1])
+	}
+	if got, want := img.Header.Size(), 0x100+4*0x10; got != want {
+		t.Errorf("header size 0x%X, want 0x%X", got, want)
+	}
+}
+
+func TestReadFileNamesCRLF(t *testing.T) {
+	f, err := os.Open("testdata/names_crlf.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names, err := ReadFileNames(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bootloader.bin", "kernel.bin", "", "rootfs.bin"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+
+	// a stray \r inside a name would end up in the extracted file name
+	if _, err = ReadFileNames(strings.NewReader("a\rb.bin\n")); err == nil {
+		t.Error("name with a carriage return accepted")
+	}
+}
+
+func TestComputeChecksum(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	img, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := img.ComputeChecksum(ScopeHeader); err != nil || sum != img.Header.Checksum {
+		t.Errorf("header scope: sum %X, err %v, want the stored %X", sum, err, img.Header.Checksum)
+	}
+
+	// the full scope must match what UpdateChecksum stores with that scope
+	full, err := ParseWithOptions(bytes.NewReader(data), Options{ChecksumScope: ScopeFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = full.UpdateChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := img.ComputeChecksum(ScopeFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != full.Header.Checksum || sum == img.Header.Checksum {
+		t.Errorf("full scope: sum %X, stored %X", sum, full.Header.Checksum)
+	}
+}
+
+// BenchmarkInject covers the inject path: parse, change the header and a file,
+// recompute the checksum and write the image.
+func BenchmarkInject(b *testing.B) {
+	data := newTestImage(b, 0x30000, 0x10000, testFiles)
+	replacement := fileContents(0, 0x1800)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		img.Header.Header.SequenceNumber++
+		if err = img.Replace(0, replacement); err != nil {
+			b.Fatal(err)
+		}
+		if err = img.UpdateChecksum(); err != nil {
+			b.Fatal(err)
+		}
+		// a writer without ReadFrom, like the fallback of *os.File for sections
+		if _, err = img.WriteTo(struct{ io.Writer }{io.Discard}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReplaceEmpty(t *testing.T) {
+	img, err := Parse(bytes.NewReader(newTestImage(t, 0x30000, 0x10000, testFiles)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := img.Files[0].Length
+	if err = img.Replace(0, nil); !errors.Is(err, ErrEmptyData) {
+		t.Fatalf("Replace() error = %v, want ErrEmptyData", err)
+	}
+	if img.Files[0].Length != length {
+		t.Errorf("Length = 0x%X after the rejected replacement, want 0x%X", img.Files[0].Length, length)
+	}
+}
+
+func TestKnownVersion(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	for _, tt := range []struct {
+		format, layout byte
+		opts           Options
+		want           bool
+	}{
+		{0x01, 0x02, Options{}, true},
+		{0x00, 0x00, Options{}, false},
+		{0x01, 0x03, Options{}, false},
+		{0x01, 0x03, Options{LayoutSlots: map[byte]int{0x03: NumFiles}}, true},
+	} {
+		// format and layout version are at offsets 4 and 6 of the header
+		data[0x10004], data[0x10006] = tt.format, tt.layout
+		img, err := ParseWithOptions(bytes.NewReader(data), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.KnownVersion(); got != tt.want {
+			t.Errorf("format 0x%02X, layout 0x%02X: KnownVersion() = %v, want %v", tt.format, tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestChecksumBefore(t *testing.T) {
+	data := newTestImage(t, 0x30000, 0x10000, testFiles)
+	img, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// store the checksum between the fixed fields and the file table
+	before := Options{ChecksumPos: ChecksumBefore}
+	raw := img.Header.EncodeWith(before)
+	if !bytes.Equal(raw[HeaderPrefixSize:HeaderPrefixSize+ChecksumSize], img.Header.Checksum[:]) {
+		t.Fatalf("checksum not stored before the file table: % X", raw)
+	}
+	copy(data[0x10000:], raw)
+
+	for _, pos := range []ChecksumPosition{ChecksumBefore, ChecksumAuto} {
+		img, err := ParseWithOptions(bytes.NewReader(data), Options{ChecksumPos: pos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = img.Verify(); err != nil {
+			t.Errorf("position %d: %v", pos, err)
+		}
+		if !reflect.DeepEqual(img.Header.Header.Files, testFiles) {
+			t.Errorf("position %d: files %+v", pos, img.Header.Header.Files)
+		}
+		if got := img.HeaderBytes(); !bytes.Equal(got, raw) {
+			t.Errorf("position %d: HeaderBytes() = % X, want % X", pos, got, raw)
+		}
+	}
+	if img, err = Parse(bytes.NewReader(data)); err == nil && img.Verify() == nil {
+		t.Error("checksum before the file table verifies in the default position")
+	}
+}
+
+// FuzzParse feeds arbitrary bytes to the parser and everything reading through the
+// parsed file table. Malformed images must produce errors, never panics.
+func FuzzParse(f *testing.F) {
+	valid := newTestImage(f, 0x1100, 0, []File{{Offset: 1, Length: 1}, {}, {Offset: 0x7FFFFFFF, Length: 0xFFFFFFFF}})
+	f.Add(byte(0), valid)
+	f.Add(byte(1), valid)
+	f.Add(byte(6), valid[:0x80])
+	f.Add(byte(0), []byte(Magic))
+	f.Add(byte(0), []byte{})
+
+	f.Fuzz(func(t *testing.T, mode byte, data []byte) {
+		// the header is expected at the start, small inputs keep the fuzzer fast
+		opts := Options{
+			HeaderOffsets:     []int64{0},
+			OnlyHeaderOffsets: true,
+			ChecksumPos:       ChecksumPosition(mode % 3),
+			LayoutSlots:       map[byte]int{0x01: 1, 0x02: 40},
+		}
+		if mode&0x04 != 0 {
+			opts.ByteOrder = binary.BigEndian
+		}
+		if mode&0x08 != 0 {
+			opts.ChecksumScope = ScopeFull
+		}
+		banks, err := ParseBanks(bytes.NewReader(data), opts)
+		if err != nil {
+			return
+		}
+		ScanHeaders(bytes.NewReader(data), opts)
+		img := banks[0]
+		img.Verify()
+		img.CheckLayout()
+		img.Unclaimed()
+		img.KnownVersion()
+		io.Copy(io.Discard, img.HeaderSection())
+		for _, fi := range img.Files {
+			name := FileName(fi.Index)
+			if fi.Length == 0x00 {
+				continue
+			}
+			img.UnknownLength(fi)
+			if r, err := img.Open(name); err == nil {
+				io.Copy(io.Discard, r)
+			}
+			img.Replace(fi.Index, []byte{1, 2, 3})
+			img.Delete(fi.Index)
+		}
+		img.PatchHeader(int(mode), []byte{0xFF})
+		if err = img.UpdateChecksum(); err == nil {
+			MergeBanks(banks).WriteTo(io.Discard)
+		}
+	})
+}
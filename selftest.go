@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// runSelftest extracts the image to a temporary directory, packs it again and
+// compares the result with the original up to the end of the last file.
+func runSelftest(args []string) error {
+	fs := newFlagSet("selftest")
+	f := addImageFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, img, err := f.open(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dir, err := os.MkdirTemp("", "sbfs-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	n, err := extractAll(dirOutput{dir, defaultMode}, file, img)
+	if err != nil {
+		return fmt.Errorf("Extracting: %w", err)
+	}
+	packed, err := os.Create(filepath.Join(dir, "repacked.img"))
+	if err != nil {
+		return err
+	}
+	defer packed.Close()
+	if _, _, err = packDir(packed, dir, opts); err != nil {
+		return fmt.Errorf("Packing: %w", err)
+	}
+	end, err := packed.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = packed.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// the repacked image starts with data.hdr, at the base of the original, and
+	// ends after the last file; anything the original holds past that is not packed
+	base := opts.Base
+	diff, sizeA, sizeB, err := firstDifference(io.NewSectionReader(file, base, end), packed)
+	if err != nil {
+		return err
+	}
+	var tail int64
+	if img.Size >= 0 {
+		sizeA = img.Size - base
+		tail = sizeA - end
+	}
+
+	fmt.Printf("\n=== SBFS Selftest ===\n")
+	fmt.Printf("%16s: %d\n", "Files Extracted", n)
+	fmt.Printf("%16s: 0x%06X\n", "Original Size", sizeA)
+	fmt.Printf("%16s: 0x%06X\n", "Repacked Size", sizeB)
+	if diff < 0 && tail > 0 {
+		fmt.Printf("%16s: 0x%06X bytes after the last file, not compared\n", "Trailing Data", tail)
+	}
+	if diff < 0 {
+		fmt.Printf("%16s: %s\n\n", "Result", "OK, identical")
+		return nil
+	}
+	fmt.Printf("%16s: first difference at 0x%06X\n\n", "Result", base+diff)
+	return errDiffers
+}
+
+// extractAll writes data.hdr, sbfs.hdr and every file in bounds to out and returns
+// the number of files written.
+func extractAll(out extractOutput, file io.ReaderAt, img *sbfs.Image) (int, error) {
+	base := img.Options().Base
+	size := img.HeaderOffset - base
+	type part struct {
+		name string
+		r    *io.SectionReader
+	}
+	parts := []part{
+		{"data.hdr", io.NewSectionReader(file, base, size)},
+		{"sbfs.hdr", img.HeaderSection()},
+	}
+	for _, f := range img.Files {
+		if f.Length != 0x00 && img.CheckBounds(f) == nil {
+			parts = append(parts, part{sbfs.FileName(f.Index), img.Section(f)})
+		}
+	}
+	for _, p := range parts {
+		w, err := out.create(p.name, p.r.Size())
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.Copy(w, p.r)
+		if err = finishCopy(w, n, p.r.Size(), err); err != nil {
+			return 0, fmt.Errorf("%s: %w", p.name, err)
+		}
+	}
+	return len(parts) - 2, out.close()
+}
+
+// firstDifference compares a and b and returns the offset of the first differing
+// byte, -1 if they are identical, along with their sizes. A shorter input differs
+// at its end.
+func firstDifference(a, b io.Reader) (diff, sizeA, sizeB int64, err error) {
+	ra, rb := bufio.NewReader(a), bufio.NewReader(b)
+	diff = -1
+	for off := int64(0); ; off++ {
+		ca, errA := ra.ReadByte()
+		cb, errB := rb.ReadByte()
+		for _, err := range []error{errA, errB} {
+			if err != nil && err != io.EOF {
+				return 0, 0, 0, err
+			}
+		}
+		if errA == nil {
+			sizeA++
+		}
+		if errB == nil {
+			sizeB++
+		}
+		if errA != nil && errB != nil {
+			return diff, sizeA, sizeB, nil
+		}
+		if diff < 0 && (errA != nil || errB != nil || ca != cb) {
+			diff = off
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// TestSelftestSecondOffset checks that an image with its header at the second
+// default offset is packed back with the header where it was.
+func TestSelftestSecondOffset(t *testing.T) {
+	data := packTestImage(t, make([]byte, 0x11000), map[int][]byte{
+		0: bytes.Repeat([]byte{0xA0}, 0x2000),
+		3: bytes.Repeat([]byte{0xA3}, 0x1000),
+	}, nil)
+	in := filepath.Join(t.TempDir(), "in.img")
+	if err := os.WriteFile(in, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	img, err := sbfs.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.HeaderOffset != 0x11000 {
+		t.Fatalf("header at 0x%X, want 0x11000", img.HeaderOffset)
+	}
+	if err = runSelftest([]string{"-f", in}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSelftestTrailingData checks that unused space after the last file, which pack
+// does not reproduce, is reported without failing the comparison.
+func TestSelftestTrailingData(t *testing.T) {
+	data := packTestImage(t, make([]byte, 0x10000), map[int][]byte{
+		0: bytes.Repeat([]byte{0xA0}, 0x2000),
+	}, nil)
+	data = append(data, bytes.Repeat([]byte{0xFF}, 0x10000)...)
+	in := filepath.Join(t.TempDir(), "in.img")
+	if err := os.WriteFile(in, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSelftest([]string{"-f", in}); err != nil {
+		t.Fatal(err)
+	}
+}
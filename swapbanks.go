@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RetroTechCorner/sbfs-tool/sbfs"
+)
+
+// runSwapBanks makes the other bank of an A/B image the active one. File offsets are
+// absolute, so the bank contents cannot simply trade places; instead the sequence
+// numbers the device selects the bank by are exchanged. With -promote the chosen
+// bank is copied over the other one instead, with the next sequence number.
+func runSwapBanks(args []string) error {
+	fs := newFlagSet("swap-banks")
+	f := addImageFlags(fs)
+	promote := fs.String("promote", "", "Instead of swapping, copy bank a or b over the other bank with the next sequence number")
+	outputFile := fs.String("o", "", "output file (default: input file + .out). Using the input file keeps a .bak copy")
+	noVerify := fs.Bool("no-verify", false, "Do not parse the written image again to check its headers and checksums")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if isFlagPassed(fs, "bank") {
+		return argErrorf("-bank cannot be used with swap-banks")
+	}
+	if *promote != "" && *promote != "a" && *promote != "b" {
+		return argErrorf("Invalid bank: %s", *promote)
+	}
+	if *f.input == "-" && !isFlagPassed(fs, "o") {
+		return argErrorf("-o is required when reading the image from stdin")
+	}
+	*f.bank = "all"
+	opts, err := f.options(fs)
+	if err != nil {
+		return err
+	}
+	file, banks, err := f.openBanks(opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if len(banks) != 2 {
+		return fmt.Errorf("%s: expected 2 banks, found %d: %w", *f.input, len(banks), sbfs.ErrNoHeader)
+	}
+
+	if *promote != "" {
+		src, dst := 0, 1
+		if *promote == "b" {
+			src, dst = 1, 0
+		}
+		fmt.Printf("\n=== Promoting SBFS Bank ===\n")
+		if err = sbfs.CopyBank(banks[src], banks[dst]); err != nil {
+			return fmt.Errorf("Cannot copy bank %s over bank %s: %w", bankName(src), bankName(dst), err)
+		}
+		fmt.Printf("%20s: bank %s to 0x%06X\n", "Copied", bankName(src), banks[dst].HeaderOffset)
+	} else {
+		a, b := &banks[0].Header.Header, &banks[1].Header.Header
+		fmt.Printf("\n=== Swapping SBFS Banks ===\n")
+		a.SequenceNumber, b.SequenceNumber = b.SequenceNumber, a.SequenceNumber
+		for _, img := range banks {
+			if err = img.UpdateChecksum(); err != nil {
+				return fmt.Errorf("Cannot compute checksum: %w", err)
+			}
+		}
+	}
+	for i, img := range banks {
+		fmt.Printf("%20s: 0x%02X\n", "Bank "+bankName(i)+" sequence", img.Header.Header.SequenceNumber)
+	}
+	if i, err := sbfs.ActiveBank(banks); err == nil {
+		fmt.Printf("%20s: %s\n", "Active bank", bankName(i))
+	}
+
+	outFileName := *f.input + ".out"
+	if isFlagPassed(fs, "o") {
+		outFileName = *outputFile
+	}
+	return writeImage(file, sbfs.MergeBanks(banks), *f.input, outFileName, false, true, !*noVerify, banks[1])
+}